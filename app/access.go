@@ -0,0 +1,69 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+)
+
+// accessKey is the context key under which TokenAuthMiddleware keeps the
+// AccessDetails of the request, so that they are looked up once.
+const accessKey = "access"
+
+// requestAccess returns the AccessDetails that TokenAuthMiddleware found for
+// the request.
+func requestAccess(c *gin.Context) (*authentication.AccessDetails, error) {
+	if au, ok := c.Get(accessKey); ok {
+		return au.(*authentication.AccessDetails), nil
+	}
+	return nil, authentication.ErrInvalidAccessToken
+}
+
+// requestUserID returns the id of the user that TokenAuthMiddleware found for
+// the request.
+func requestUserID(c *gin.Context) (uint64, error) {
+	au, err := requestAccess(c)
+	if err != nil {
+		return 0, err
+	}
+	return au.UserId, nil
+}
+
+// requireTaskRole checks that the user has at least the role min on the task.
+// It answers the request itself when the user has not.  A task the user may
+// not access at all is not found, so that its existence is not disclosed.
+func (app *App) requireTaskRole(c *gin.Context, userId uint64, taskId uint64, min string) bool {
+	role, err := app.db.TaskRole(userId, taskId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return false
+	}
+	switch {
+	case role == "":
+		c.JSON(http.StatusNotFound, "task not found")
+		return false
+	case !models.RoleAtLeast(role, min):
+		c.JSON(http.StatusForbidden, "the task requires the "+min+" role")
+		return false
+	}
+	return true
+}
+
+// requireListRole is requireTaskRole for lists.  It returns the list.
+func (app *App) requireListRole(c *gin.Context, userId uint64, listId uint64, min string) (*models.List, bool) {
+	list, err := app.db.GetList(userId, listId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	switch {
+	case list == nil:
+		c.JSON(http.StatusNotFound, "list not found")
+		return nil, false
+	case !models.RoleAtLeast(list.Role, min):
+		c.JSON(http.StatusForbidden, "the list requires the "+min+" role")
+		return nil, false
+	}
+	return list, true
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// userView is a user as the admin API shows it, without the password hash.
+type userView struct {
+	ID          uint64     `json:"id"`
+	Email       string     `json:"email"`
+	FirstName   string     `json:"first-name"`
+	LastName    string     `json:"last-name"`
+	Role        string     `json:"role"`
+	Pending     bool       `json:"pending"`
+	Verified    bool       `json:"verified"`
+	SuspendedAt *time.Time `json:"suspended-at,omitempty"`
+	CreatedAt   time.Time  `json:"created-at"`
+}
+
+type userRole struct {
+	Role string `json:"role"`
+}
+
+func viewUser(u *models.User) userView {
+	return userView{
+		ID:          u.ID,
+		Email:       u.Email,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Role:        u.Role,
+		Pending:     u.Pending != nil && *u.Pending,
+		Verified:    u.VerifiedAt != nil,
+		SuspendedAt: u.SuspendedAt,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+// userIdParam parses the user-id path parameter.  It answers the request
+// itself when the parameter is invalid, or names the admin making the request,
+// who may not act on their own account through the admin API.
+func (app *App) userIdParam(c *gin.Context) (uint64, bool) {
+	userId, err := strconv.ParseUint(c.Param("user-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid user-id")
+		return 0, false
+	}
+	adminId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return 0, false
+	}
+	if userId == adminId {
+		c.JSON(http.StatusUnprocessableEntity, "admins cannot change their own account")
+		return 0, false
+	}
+	return userId, true
+}
+
+// respondUserRows answers a request that changed rows users.  The sessions of
+// a changed user end, so that the change takes effect at once.
+func (app *App) respondUserRows(c *gin.Context, userId uint64, rows int64) {
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "user not found")
+	case 1:
+		if err := app.auth.RevokeUserTokens(userId); err != nil {
+			glog.Error("Error revoking sessions:", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
+
+// AdminGetUsers lists users in order of ids.  Pages continue with after-id.
+func (app *App) AdminGetUsers(c *gin.Context) {
+	if _, err := requestUserID(c); err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	filter := models.UserFilter{Text: c.Query("q"), Role: c.Query("role")}
+	if filter.Role != "" && !models.ValidUserRole(filter.Role) {
+		c.JSON(http.StatusUnprocessableEntity, "invalid role")
+		return
+	}
+	var err error
+	if afterId := c.Query("after-id"); afterId != "" {
+		if filter.AfterID, err = strconv.ParseUint(afterId, 10, 64); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, "invalid after-id")
+			return
+		}
+	}
+	filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+		c.JSON(http.StatusUnprocessableEntity, "invalid limit")
+		return
+	}
+
+	users, err := app.db.GetUsers(filter)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	views := []userView{}
+	for i := range users {
+		views = append(views, viewUser(&users[i]))
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// AdminGetTodo shows any task, for support cases.
+func (app *App) AdminGetTodo(c *gin.Context) {
+	taskId, err := strconv.ParseUint(c.Param("task-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
+		return
+	}
+	staffId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	td, err := app.db.GetToDo(taskId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if td == nil {
+		c.JSON(http.StatusNotFound, "task not found")
+		return
+	}
+	glog.Infof("User %d viewed task %d of user %d", staffId, taskId, td.UserID)
+	c.JSON(http.StatusOK, td)
+}
+
+func (app *App) AdminSetUserRole(c *gin.Context) {
+	var role userRole
+	if err := c.ShouldBindJSON(&role); err != nil || !models.ValidUserRole(role.Role) {
+		c.JSON(http.StatusUnprocessableEntity, "role must be user, support or admin")
+		return
+	}
+	userId, ok := app.userIdParam(c)
+	if !ok {
+		return
+	}
+	rows, err := app.db.SetUserRole(userId, role.Role)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	app.respondUserRows(c, userId, rows)
+}
+
+// AdminSuspendUser keeps a user from logging in and ends their sessions.
+func (app *App) AdminSuspendUser(c *gin.Context) {
+	now := time.Now()
+	app.suspendUser(c, &now)
+}
+
+func (app *App) AdminUnsuspendUser(c *gin.Context) {
+	app.suspendUser(c, nil)
+}
+
+func (app *App) suspendUser(c *gin.Context, at *time.Time) {
+	userId, ok := app.userIdParam(c)
+	if !ok {
+		return
+	}
+	rows, err := app.db.SuspendUser(userId, at)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	app.respondUserRows(c, userId, rows)
+}
+
+// AdminDeleteUser soft deletes a user.  Their email address stays taken.
+func (app *App) AdminDeleteUser(c *gin.Context) {
+	userId, ok := app.userIdParam(c)
+	if !ok {
+		return
+	}
+	rows, err := app.db.DeleteUser(userId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	app.respondUserRows(c, userId, rows)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxAPIKeyName = 100
+
+type newAPIKey struct {
+	Name string `json:"name"`
+	// Scopes must be granted to the access token that creates the key.
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional.  Keys without it work until they are revoked.
+	ExpiresAt *time.Time `json:"expires-at"`
+}
+
+// createdAPIKey is the only answer that shows the key itself.
+type createdAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey creates a personal API key, which scripts send instead of an
+// access token.  Keys cannot create further keys.
+func (app *App) CreateAPIKey(c *gin.Context) {
+	var request newAPIKey
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	if request.Name == "" || len(request.Name) > maxAPIKeyName {
+		c.JSON(http.StatusUnprocessableEntity, "name is required and at most "+strconv.Itoa(maxAPIKeyName)+" bytes long")
+		return
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusUnprocessableEntity, "expires-at must be in the future")
+		return
+	}
+	if len(request.Scopes) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, "scopes are required")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !authentication.ValidScope(scope) {
+			c.JSON(http.StatusUnprocessableEntity, "invalid scope: "+scope)
+			return
+		}
+	}
+	access, err := requestAccess(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if access.APIKeyID != 0 {
+		c.JSON(http.StatusForbidden, "API keys cannot create API keys")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !authentication.HasScope(access.Scopes, scope) {
+			c.JSON(http.StatusForbidden, "missing scope: "+scope)
+			return
+		}
+	}
+
+	key, prefix, hash, err := authentication.NewAPIKey()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	apiKey := models.APIKey{
+		UserID:    access.UserId,
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err = app.db.CreateAPIKey(&apiKey); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, createdAPIKey{APIKey: apiKey, Key: key})
+}
+
+// GetAPIKeys lists the keys of the user that have not been revoked.
+func (app *App) GetAPIKeys(c *gin.Context) {
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	keys, err := app.db.GetAPIKeys(userId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (app *App) RevokeAPIKey(c *gin.Context) {
+	keyId, err := strconv.ParseUint(c.Param("key-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid key-id")
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rows, err := app.db.RevokeAPIKey(userId, keyId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, "API key not found")
+		return
+	}
+	c.JSON(http.StatusOK, "API key revoked")
+}
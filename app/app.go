@@ -2,29 +2,46 @@ package app
 
 import (
 	"crypto/tls"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-gomail/gomail"
-	_ "github.com/go-redis/redis/v7"
 	"github.com/golang/glog"
 	_ "github.com/lib/pq"
 	"github.com/tintash-training/todo-api/app/authentication"
 	"github.com/tintash-training/todo-api/app/config"
 	"github.com/tintash-training/todo-api/app/models"
+	"github.com/tintash-training/todo-api/app/password"
 	_ "github.com/twinj/uuid"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type App struct {
 	router *gin.Engine
 	auth   *authentication.Auth
+	hasher *password.Hasher
 	config *config.Config
-	//db     models.Datastore
+	db     models.Datastore
 }
 
 func (app *App) Start(config *config.Config) {
-	auth, err := authentication.CreateAuthenticator(config.AuthConfig)
+	db, err := models.ConnectDS(config.DBConfig)
+	if err != nil {
+		panic(err)
+	}
+	auth, err := authentication.CreateAuthenticator(config.AuthConfig, db)
+	if err != nil {
+		panic(err)
+	}
+	if config.AuthConfig.SigningKeyFile == "" {
+		glog.Warning("TODO_JWT_EPHEMERAL_KEY set, tokens are signed with a key that is lost on restart")
+	}
+	if config.AuthConfig.ActionSecret == "" {
+		glog.Warning("No TODO_ACTION_SECRET given, emailed links are signed with a secret that is lost on restart")
+	}
+	hasher, err := password.CreateHasher(config.PasswordConfig)
 	if err != nil {
 		panic(err)
 	}
@@ -32,7 +49,12 @@ func (app *App) Start(config *config.Config) {
 	app.config = config
 	app.router = gin.Default()
 	app.auth = auth
+	app.hasher = hasher
+	app.db = db
 	app.initRouters()
+	if config.ReminderConfig.Interval > 0 {
+		go app.runReminders(config.ReminderConfig.Interval)
+	}
 
 	app.run(":8080")
 }
@@ -42,49 +64,213 @@ func (app *App) run(addr string) {
 }
 
 func (app *App) initRouters() {
+	read := app.RequireScope(authentication.ScopeTasksRead)
+	write := app.RequireScope(authentication.ScopeTasksWrite)
+	account := app.RequireScope(authentication.ScopeAccount)
+
+	app.router.GET("/health", app.Health)
+	app.router.GET("/.well-known/jwks.json", app.JWKS)
 	app.router.POST("/register", app.Register)
 	app.router.POST("/login", app.Login)
-	app.router.POST("/migrate", app.CreateTables)
-	app.router.POST("/add-task", TokenAuthMiddleware(), app.CreateTodo)
-	app.router.POST("/assign-task", TokenAuthMiddleware(), app.AssignTodo)
-	app.router.PUT("/update-task/:task-id", TokenAuthMiddleware(), app.UpdateTodo)
-	app.router.DELETE("/delete-task/:task-id", TokenAuthMiddleware(), app.DeleteTodo)
-	app.router.GET("/list-tasks", TokenAuthMiddleware(), app.GetAllTasks)
-	app.router.POST("/logout", TokenAuthMiddleware(), app.Logout)
+	app.router.POST("/token/refresh", app.Refresh)
+	app.router.GET("/verify-email", app.VerifyEmail)
+	app.router.POST("/resend-verification", app.ResendVerification)
+	app.router.POST("/forgot-password", app.ForgotPassword)
+	app.router.POST("/reset-password", app.ResetPassword)
+	app.router.POST("/login-2fa", app.LoginTwoFactor)
+	app.router.POST("/enroll-2fa", app.TokenAuthMiddleware(), account, app.EnrollTwoFactor)
+	app.router.POST("/confirm-2fa", app.TokenAuthMiddleware(), account, app.ConfirmTwoFactor)
+	app.router.POST("/disable-2fa", app.TokenAuthMiddleware(), account, app.DisableTwoFactor)
+	app.router.POST("/add-task", app.TokenAuthMiddleware(), write, app.CreateTodo)
+	app.router.POST("/assign-task", app.TokenAuthMiddleware(), write, app.AssignTodo)
+	app.router.PUT("/update-task/:task-id", app.TokenAuthMiddleware(), write, app.UpdateTodo)
+	app.router.DELETE("/delete-task/:task-id", app.TokenAuthMiddleware(), write, app.DeleteTodo)
+	app.router.POST("/complete-task/:task-id", app.TokenAuthMiddleware(), write, app.CompleteTodo)
+	app.router.POST("/reopen-task/:task-id", app.TokenAuthMiddleware(), write, app.ReopenTodo)
+	app.router.POST("/reorder-task/:task-id", app.TokenAuthMiddleware(), write, app.ReorderTodo)
+	app.router.POST("/accept-task/:task-id", app.TokenAuthMiddleware(), write, app.AcceptTodo)
+	app.router.POST("/decline-task/:task-id", app.TokenAuthMiddleware(), write, app.DeclineTodo)
+	app.router.GET("/list-tasks", app.TokenAuthMiddleware(), read, app.GetAllTasks)
+	app.router.GET("/list-tasks/overdue", app.TokenAuthMiddleware(), read, app.GetOverdueTasks)
+	app.router.GET("/list-tasks/due-today", app.TokenAuthMiddleware(), read, app.GetTasksDueToday)
+	app.router.GET("/list-tasks/upcoming", app.TokenAuthMiddleware(), read, app.GetUpcomingTasks)
+	app.router.GET("/list-tasks/delegated", app.TokenAuthMiddleware(), read, app.GetDelegatedTasks)
+	app.router.GET("/search-tasks", app.TokenAuthMiddleware(), read, app.SearchTasks)
+	app.router.POST("/move-task/:task-id", app.TokenAuthMiddleware(), write, app.MoveTodo)
+	app.router.POST("/add-list", app.TokenAuthMiddleware(), write, app.CreateList)
+	app.router.GET("/list-lists", app.TokenAuthMiddleware(), read, app.GetLists)
+	app.router.PUT("/update-list/:list-id", app.TokenAuthMiddleware(), write, app.UpdateList)
+	app.router.DELETE("/delete-list/:list-id", app.TokenAuthMiddleware(), write, app.DeleteList)
+	app.router.POST("/archive-list/:list-id", app.TokenAuthMiddleware(), write, app.ArchiveList)
+	app.router.POST("/unarchive-list/:list-id", app.TokenAuthMiddleware(), write, app.UnarchiveList)
+	app.router.POST("/share-list/:list-id", app.TokenAuthMiddleware(), write, app.ShareList)
+	app.router.POST("/unshare-list/:list-id", app.TokenAuthMiddleware(), write, app.UnshareList)
+	app.router.GET("/list-members/:list-id", app.TokenAuthMiddleware(), read, app.GetListMembers)
+	app.router.POST("/resend-invitation", app.TokenAuthMiddleware(), write, app.ResendInvitation)
+	app.router.POST("/revoke-invitation", app.TokenAuthMiddleware(), write, app.RevokeInvitation)
+	app.router.POST("/logout", app.TokenAuthMiddleware(), app.Logout)
+	app.router.POST("/logout-all", app.TokenAuthMiddleware(), account, app.LogoutAll)
+	app.router.GET("/list-sessions", app.TokenAuthMiddleware(), account, app.GetSessions)
+	app.router.POST("/revoke-session/:session-id", app.TokenAuthMiddleware(), account, app.RevokeSession)
+	app.router.POST("/create-api-key", app.TokenAuthMiddleware(), account, app.CreateAPIKey)
+	app.router.GET("/list-api-keys", app.TokenAuthMiddleware(), account, app.GetAPIKeys)
+	app.router.POST("/revoke-api-key/:key-id", app.TokenAuthMiddleware(), account, app.RevokeAPIKey)
+
+	staff := app.RequireRole(models.UserRoleSupport, models.UserRoleAdmin)
+	admin := app.RequireRole(models.UserRoleAdmin)
+	adminRead := app.RequireScope(authentication.ScopeAdminRead)
+	adminScope := app.RequireScope(authentication.ScopeAdmin)
+	app.router.GET("/admin/list-users", app.TokenAuthMiddleware(), staff, adminRead, app.AdminGetUsers)
+	app.router.GET("/admin/view-task/:task-id", app.TokenAuthMiddleware(), staff, adminRead, app.AdminGetTodo)
+	app.router.POST("/admin/set-role/:user-id", app.TokenAuthMiddleware(), admin, adminScope, app.AdminSetUserRole)
+	app.router.POST("/admin/suspend-user/:user-id", app.TokenAuthMiddleware(), admin, adminScope, app.AdminSuspendUser)
+	app.router.POST("/admin/unsuspend-user/:user-id", app.TokenAuthMiddleware(), admin, adminScope, app.AdminUnsuspendUser)
+	app.router.DELETE("/admin/delete-user/:user-id", app.TokenAuthMiddleware(), admin, adminScope, app.AdminDeleteUser)
+}
+
+// JWKS publishes the public keys that verify access tokens, so that other
+// services can verify them without a shared secret.
+func (app *App) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": app.auth.JWKS()})
+}
+
+// Health reports whether the database and the token store can be reached.
+func (app *App) Health(c *gin.Context) {
+	status := http.StatusOK
+	health := gin.H{"db": "ok", "token-store": "ok"}
+	if err := app.db.Ping(); err != nil {
+		glog.Error("Database health check failed:", err)
+		status = http.StatusServiceUnavailable
+		health["db"] = "unavailable"
+	}
+	if err := app.auth.Ping(); err != nil {
+		glog.Error("Token store health check failed:", err)
+		status = http.StatusServiceUnavailable
+		health["token-store"] = "unavailable"
+	}
+	c.JSON(status, health)
+}
+
+// authenticate returns the user with the given credentials.  It answers the
+// request itself when they are not valid.
+func (app *App) authenticate(c *gin.Context, email string, password string) (*models.User, bool) {
+	user, err := app.db.ReadUser(email)
+	if err != nil || user == nil {
+		c.JSON(http.StatusUnauthorized, "Please provide valid login details")
+		return nil, false
+	}
+
+	match, rehash, err := app.hasher.Verify(password, user.Password)
+	if err != nil {
+		glog.Error("Error verifying password:", err)
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if strings.ToLower(email) != user.Email || !match {
+		c.JSON(http.StatusUnauthorized, "Please provide valid login details")
+		return nil, false
+	}
+
+	if rehash {
+		// Upgrade plaintext or outdated hashes now that we know the password.
+		hash, err := app.hasher.Hash(password)
+		if err == nil {
+			err = app.db.UpdatePassword(user.ID, hash)
+		}
+		if err != nil {
+			glog.Warning("Error rehashing password:", err)
+		}
+	}
+	return user, true
+}
+
+// credentials is the request of Login.  Device optionally names the device
+// in the list of sessions.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Device   string `json:"device"`
+	// Scope optionally limits the tokens to some of the scopes of the role,
+	// separated by spaces.
+	Scope string `json:"scope"`
 }
 
 func (app *App) Login(c *gin.Context) {
-	var u models.User
+	var u credentials
 	if err := c.ShouldBindJSON(&u); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
 		return
 	}
-
-	db, err := models.ConnectDS(app.config.DBConfig)
+	scopes, err := authentication.ParseScope(u.Scope)
 	if err != nil {
-		c.Status(http.StatusInternalServerError)
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	user, ok := app.authenticate(c, u.Email, u.Password)
+	if !ok {
 		return
 	}
-	user, err := db.ReadUser(u.Email)
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, "This account has been suspended")
+		return
+	}
+	if app.config.AuthConfig.RequireVerifiedEmail && user.VerifiedAt == nil {
+		c.JSON(http.StatusForbidden, "Please verify your email address before logging in")
+		return
+	}
+
+	scopes, err = authentication.GrantedScopes(scopes, user.Role)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, "Please provide valid login details")
+		c.JSON(http.StatusForbidden, err.Error())
 		return
 	}
 
-	//compare the user from the request, with the one we defined:
-	if strings.ToLower(u.Email) != user.Email || u.Password != user.Password {
-		c.JSON(http.StatusUnauthorized, "Please provide valid login details")
+	if user.TOTPEnabledAt != nil {
+		app.challengeSecondFactor(c, user, scopes)
 		return
 	}
+	app.issueTokens(c, user, u.Device, scopes)
+}
 
-	ts, err := app.auth.CreateToken(user.ID)
+// issueTokens answers a successful login with the tokens of a new session,
+// which have the scopes granted by GrantedScopes.
+func (app *App) issueTokens(c *gin.Context, user *models.User, device string, scopes []string) {
+	ts, err := app.auth.CreateScopedToken(user.ID, user.Role, scopes)
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 	saveErr := app.auth.CreateAuth(user.ID, ts)
+	if saveErr == nil {
+		saveErr = app.auth.StartSession(ts, device, c.ClientIP(), c.Request.UserAgent())
+	}
 	if saveErr != nil {
 		c.JSON(http.StatusUnprocessableEntity, saveErr.Error())
+		return
+	}
+	tokens := map[string]string{
+		"access_token":  ts.AccessToken,
+		"refresh_token": ts.RefreshToken,
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (app *App) Refresh(c *gin.Context) {
+	var body map[string]string
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+
+	ts, err := app.auth.Refresh(body["refresh_token"], c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if err == authentication.ErrRefreshTokenReused {
+			glog.Warning("Refresh token reuse detected, token family revoked")
+		}
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
 	}
 	tokens := map[string]string{
 		"access_token":  ts.AccessToken,
@@ -99,27 +285,33 @@ func (app *App) UpdateTodo(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, "invalid json")
 		return
 	}
+	if err := ntd.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if ntd.ListID != nil {
+		c.JSON(http.StatusUnprocessableEntity, "list-id is changed with move-task")
+		return
+	}
 	taskIdStr := c.Param("task-id")
 	taskId, err := strconv.ParseUint(taskIdStr, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
 		return
 	}
-	userId, err := app.auth.ExtractAndFetchAuth(c.Request)
+	userId, err := requestUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	db, err := models.ConnectDS(app.config.DBConfig)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
+	if !app.requireTaskRole(c, userId, taskId, models.RoleEditor) {
 		return
 	}
 
 	td := models.Todo{ID: taskId, NewTodo: *ntd, UserID: userId}
 
-	rows, err := db.UpdateToDo(&td)
+	rows, err := app.db.UpdateToDo(&td)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
@@ -135,63 +327,182 @@ func (app *App) UpdateTodo(c *gin.Context) {
 	}
 }
 
-func (app *App) AssignTodo(c *gin.Context) {
-	var atd *models.AssignedTodo
-	if err := c.ShouldBindJSON(&atd); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+func (app *App) CompleteTodo(c *gin.Context) {
+	app.setTodoStatus(c, models.StatusDone)
+}
+
+func (app *App) ReopenTodo(c *gin.Context) {
+	app.setTodoStatus(c, models.StatusOpen)
+}
+
+func (app *App) setTodoStatus(c *gin.Context, status string) {
+	taskIdStr := c.Param("task-id")
+	taskId, err := strconv.ParseUint(taskIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
 		return
 	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if !app.requireTaskRole(c, userId, taskId, models.RoleEditor) {
+		return
+	}
+
+	td := models.Todo{ID: taskId, NewTodo: models.NewTodo{Status: status}, UserID: userId}
+
+	rows, err := app.db.UpdateToDo(&td)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "task not found")
+	case 1:
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
+
+// taskMove names the task that a reordered task is moved right before or after.
+type taskMove struct {
+	Before uint64 `json:"before"`
+	After  uint64 `json:"after"`
+}
 
-	_, err := app.auth.ExtractAndFetchAuth(c.Request)
+// ReorderTodo moves a task before or after another one in the manual order
+// returned by list-tasks?sort=position.
+func (app *App) ReorderTodo(c *gin.Context) {
+	taskIdStr := c.Param("task-id")
+	taskId, err := strconv.ParseUint(taskIdStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
+		return
+	}
+	var move taskMove
+	if err := c.ShouldBindJSON(&move); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	if (move.Before == 0) == (move.After == 0) {
+		c.JSON(http.StatusUnprocessableEntity, "either before or after is required")
+		return
+	}
+	anchorId, after := move.Before, false
+	if move.After != 0 {
+		anchorId, after = move.After, true
+	}
+	if anchorId == taskId {
+		c.JSON(http.StatusUnprocessableEntity, "a task cannot be moved next to itself")
+		return
+	}
+	userId, err := requestUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	db, err := models.ConnectDS(app.config.DBConfig)
+	if !app.requireTaskRole(c, userId, taskId, models.RoleEditor) {
+		return
+	}
+
+	rows, err := app.db.MoveToDo(userId, taskId, anchorId, after)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "task not found")
+	case 1:
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
 
-	// Lookup the user by email
-	user, err := db.ReadUser(atd.Email)
+// AcceptTodo accepts a task that was assigned to the user.
+func (app *App) AcceptTodo(c *gin.Context) {
+	app.answerAssignment(c, models.AssignmentAccepted)
+}
+
+// DeclineTodo declines a task that was assigned to the user.  The task stays
+// with the user, who may delete it, and its assigner sees the answer.
+func (app *App) DeclineTodo(c *gin.Context) {
+	app.answerAssignment(c, models.AssignmentDeclined)
+}
+
+func (app *App) answerAssignment(c *gin.Context, assignment string) {
+	taskId, err := strconv.ParseUint(c.Param("task-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	rows, err := app.db.AnswerAssignment(userId, taskId, assignment)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "no pending assignment of the task")
+	case 1:
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
 
-	if user == nil {
-		// User not registered.  Create a temporary registration and notify the user by email.
-		Pending := true
-		newUser := &models.NewUser{Email: atd.Email, Pending: &Pending}
+func (app *App) AssignTodo(c *gin.Context) {
+	var atd *models.AssignedTodo
+	if err := c.ShouldBindJSON(&atd); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	if err := atd.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if atd.ListID != nil {
+		// The list of the assigner is not one of the assignee's.
+		c.JSON(http.StatusUnprocessableEntity, "list-id cannot be set on an assigned task")
+		return
+	}
 
-		err = db.CreateUser(newUser)
-		if err != nil {
-			c.Status(http.StatusInternalServerError)
-			return
-		}
-		user, err = db.ReadUser(atd.Email)
-		if err != nil || user == nil {
-			// User was created above and must be found here.
-			c.Status(http.StatusInternalServerError)
-			return
-		}
+	assignerId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
 	}
 
-	if *user.Pending {
-		err = app.sendRegistrationEmail(atd)
-		if err != nil {
-			// TODO we have created a user but have not been able to send the email.
-			glog.Error("Error sending email:", err)
-			c.Status(http.StatusInternalServerError)
-			return
-		}
+	user, err := app.lookupOrInvite(atd.Email, "A task has been assigned to you.", atd.Title)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if user.ID == assignerId {
+		c.JSON(http.StatusUnprocessableEntity, "tasks cannot be assigned to oneself")
+		return
 	}
 
-	td := models.Todo{NewTodo: atd.NewTodo, UserID: user.ID}
+	td := models.Todo{NewTodo: atd.NewTodo, UserID: user.ID, AssignerID: &assignerId,
+		Assignment: models.AssignmentPending}
 
-	err = db.SaveToDo(&td)
+	err = app.db.SaveToDo(&td)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
@@ -208,22 +519,26 @@ func (app *App) CreateTodo(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, "invalid json")
 		return
 	}
+	if err := ntd.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
 
-	userId, err := app.auth.ExtractAndFetchAuth(c.Request)
+	userId, err := requestUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	td := models.Todo{NewTodo: *ntd, UserID: userId}
-
-	db, err := models.ConnectDS(app.config.DBConfig)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
-		return
+	if ntd.ListID != nil {
+		if _, ok := app.requireListRole(c, userId, *ntd.ListID, models.RoleEditor); !ok {
+			return
+		}
 	}
 
-	err = db.SaveToDo(&td)
+	td := models.Todo{NewTodo: *ntd, UserID: userId}
+
+	err = app.db.SaveToDo(&td)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
@@ -243,49 +558,69 @@ func (app *App) Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, "Successfully logged out")
 }
 
-func (app *App) CreateTables(c *gin.Context) {
-	ds, err := models.ConnectDS(app.config.DBConfig)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
-		return
-	}
-
-	err = ds.CreateTables()
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
-		return
-	}
-	c.JSON(http.StatusOK, "Successfully initialized DB tables")
+// registration is the request of Register.  Pending users need the invitation
+// that was emailed to them.
+type registration struct {
+	models.NewUser
+	Invitation string `json:"invitation"`
 }
 
 func (app *App) Register(c *gin.Context) {
-	var u models.NewUser
-	if err := c.ShouldBindJSON(&u); err != nil {
+	var r registration
+	if err := c.ShouldBindJSON(&r); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
 		return
 	}
-
-	db, err := models.ConnectDS(app.config.DBConfig)
+	u := r.NewUser
+	if u.Password == "" {
+		c.JSON(http.StatusUnprocessableEntity, "password is required")
+		return
+	}
+	hash, err := app.hasher.Hash(u.Password)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
-	user, err := db.ReadUser(u.Email)
+	u.Password = hash
+
+	user, err := app.db.ReadUser(u.Email)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
 	if user == nil {
-		// This is a brand new user
-		err = db.CreateUser(&u)
+		// This is a brand new user.  The email address is verified separately.
+		err = app.db.CreateUser(&u)
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
 		}
-		c.JSON(http.StatusOK, "User created successfully")
+		user, err = app.db.ReadUser(u.Email)
+		if err == nil && user != nil {
+			err = app.sendVerificationEmail(user)
+		}
+		if err != nil {
+			// The user can ask for another email with resend-verification.
+			glog.Error("Error sending verification email:", err)
+		}
+		c.JSON(http.StatusOK, "User created successfully, please verify your email address")
 	} else if *user.Pending {
-		err = db.UpdateUser(&u)
+		if r.Invitation == "" {
+			c.JSON(http.StatusForbidden, "an invitation is required to register "+user.Email)
+			return
+		}
+		if err = app.useInvitation(user, r.Invitation); err == authentication.ErrInvalidActionToken {
+			c.JSON(http.StatusForbidden, "invalid or expired invitation")
+			return
+		} else if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		// The invitation was emailed, so it proves the address too.
+		now := time.Now()
+		u.VerifiedAt = &now
+		err = app.db.UpdateUser(&u)
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
@@ -298,24 +633,191 @@ func (app *App) Register(c *gin.Context) {
 }
 
 func (app *App) GetAllTasks(c *gin.Context) {
-	userId, err := app.auth.ExtractAndFetchAuth(c.Request)
+	query, ok := parseTaskQuery(c)
+	if !ok {
+		return
+	}
+	app.listTasks(c, query)
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// parseTaskQuery reads the filter, sort and page parameters shared by the task
+// lists.  It answers the request itself when a parameter is invalid.
+func parseTaskQuery(c *gin.Context) (query models.TaskQuery, ok bool) {
+	query.Status = c.Query("status")
+	if query.Status != "" && !models.ValidStatus(query.Status) {
+		c.JSON(http.StatusUnprocessableEntity, "invalid status")
+		return
+	}
+	query.Text = c.Query("q")
+	query.Assignment = c.Query("assignment")
+	if query.Assignment != "" && !models.ValidAssignment(query.Assignment) {
+		c.JSON(http.StatusUnprocessableEntity, "invalid assignment")
+		return
+	}
+	switch listId := c.Query("list-id"); listId {
+	case "":
+	case "none":
+		query.ListID = new(uint64)
+	default:
+		id, err := strconv.ParseUint(listId, 10, 64)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusUnprocessableEntity, "invalid list-id")
+			return
+		}
+		query.ListID = &id
+	}
+	for _, param := range []struct {
+		name string
+		time **time.Time
+	}{
+		{"created-after", &query.CreatedAfter},
+		{"created-before", &query.CreatedBefore},
+		{"due-after", &query.DueAfter},
+		{"due-before", &query.DueBefore},
+	} {
+		if value := c.Query(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, "invalid "+param.name)
+				return
+			}
+			*param.time = &t
+		}
+	}
+	query.Sort = c.DefaultQuery("sort", models.SortCreated)
+	if !models.ValidSort(query.Sort) {
+		c.JSON(http.StatusUnprocessableEntity, "invalid sort")
+		return
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		c.JSON(http.StatusUnprocessableEntity, "invalid order")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusUnprocessableEntity, "invalid limit")
+		return
+	}
+	query.Limit = limit
+	if token := c.Query("page-token"); token != "" {
+		if query.Cursor, err = models.DecodeTaskCursor(token); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, "invalid page-token")
+			return
+		}
+	}
+	return query, true
+}
+
+// GetOverdueTasks lists unfinished tasks whose due date has passed.
+func (app *App) GetOverdueTasks(c *gin.Context) {
+	query, ok := parseTaskQuery(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	query.Unfinished, query.DueAfter, query.DueBefore = true, nil, &now
+	app.listTasks(c, query)
+}
+
+// GetTasksDueToday lists unfinished tasks due on the current day in the time
+// zone given by the tz parameter, or UTC.
+func (app *App) GetTasksDueToday(c *gin.Context) {
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid tz")
+		return
+	}
+	query, ok := parseTaskQuery(c)
+	if !ok {
+		return
+	}
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 1)
+	query.Unfinished, query.DueAfter, query.DueBefore = true, &start, &end
+	app.listTasks(c, query)
+}
+
+// GetUpcomingTasks lists unfinished tasks due within the next days, 7 by default.
+func (app *App) GetUpcomingTasks(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 || days > 366 {
+		c.JSON(http.StatusUnprocessableEntity, "invalid days")
+		return
+	}
+	query, ok := parseTaskQuery(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	end := now.AddDate(0, 0, days)
+	query.Unfinished, query.DueAfter, query.DueBefore = true, &now, &end
+	app.listTasks(c, query)
+}
+
+// SearchTasks lists the tasks whose title or description contains every word of
+// the q parameter, best matches first.  A word also matches the start of a longer one.
+func (app *App) SearchTasks(c *gin.Context) {
+	text := c.Query("q")
+	if strings.TrimSpace(text) == "" {
+		c.JSON(http.StatusUnprocessableEntity, "missing q")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusUnprocessableEntity, "invalid limit")
+		return
+	}
+	userId, err := requestUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	db, err := models.ConnectDS(app.config.DBConfig)
+	tasks, err := app.db.SearchTasks(userId, text, limit)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	c.JSON(http.StatusOK, tasks)
+}
 
-	tasks, err := db.GetAllTasks(userId)
+// GetDelegatedTasks lists the tasks the user assigned to others, with their
+// status and the answer of the assignee.
+func (app *App) GetDelegatedTasks(c *gin.Context) {
+	query, ok := parseTaskQuery(c)
+	if !ok {
+		return
+	}
+	query.Delegated = true
+	app.listTasks(c, query)
+}
+
+func (app *App) listTasks(c *gin.Context, query models.TaskQuery) {
+	userId, err := requestUserID(c)
 	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	page, err := app.db.GetAllTasks(userId, query)
+	if err == models.ErrInvalidCursor {
+		c.JSON(http.StatusUnprocessableEntity, "invalid page-token")
+		return
+	} else if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
-	c.JSON(http.StatusOK, tasks)
+	c.JSON(http.StatusOK, page)
 }
 
 func (app *App) DeleteTodo(c *gin.Context) {
@@ -325,19 +827,17 @@ func (app *App) DeleteTodo(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
 		return
 	}
-	userId, err := app.auth.ExtractAndFetchAuth(c.Request)
+	userId, err := requestUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	db, err := models.ConnectDS(app.config.DBConfig)
-	if err != nil {
-		c.Status(http.StatusInternalServerError)
+	if !app.requireTaskRole(c, userId, taskId, models.RoleEditor) {
 		return
 	}
 
-	rows, err := db.DeleteToDo(userId, taskId)
+	rows, err := app.db.DeleteToDo(userId, taskId)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
@@ -352,20 +852,58 @@ func (app *App) DeleteTodo(c *gin.Context) {
 	}
 }
 
-func (app *App) sendRegistrationEmail(atd *models.AssignedTodo) error {
+// lookupOrInvite returns the user registered with email.  An unknown email is
+// registered as a pending user, and pending users are emailed subject and body
+// to invite them to complete their registration.
+func (app *App) lookupOrInvite(email string, subject string, body string) (*models.User, error) {
+	user, err := app.db.ReadUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		// User not registered.  Create a temporary registration and notify the user by email.
+		Pending := true
+		newUser := &models.NewUser{Email: email, Pending: &Pending}
+
+		err = app.db.CreateUser(newUser)
+		if err != nil {
+			return nil, err
+		}
+		user, err = app.db.ReadUser(email)
+		if err != nil {
+			return nil, err
+		} else if user == nil {
+			// User was created above and must be found here.
+			return nil, fmt.Errorf("pending user %s not found", email)
+		}
+	}
+
+	if *user.Pending {
+		err = app.sendRegistrationEmail(user, subject, body)
+		if err != nil {
+			// TODO we have created a user but have not been able to send the email.
+			glog.Error("Error sending email:", err)
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (app *App) sendEmail(to string, subject string, body string) error {
 	m := gomail.NewMessage()
 
 	// Set E-Mail sender
 	m.SetHeader("From", app.config.SMTPConfig.DoNotReplyEmail)
 
 	// Set E-Mail receivers
-	m.SetHeader("To", atd.Email)
+	m.SetHeader("To", to)
 
 	// Set E-Mail subject
-	m.SetHeader("Subject", "A task has been assigned to you.")
+	m.SetHeader("Subject", subject)
 
 	// Set E-Mail body. You can set plain text or html with text/html
-	m.SetBody("text/plain", atd.Title)
+	m.SetBody("text/plain", body)
 
 	// Settings for SMTP server
 	d := gomail.NewDialer(
@@ -383,14 +921,60 @@ func (app *App) sendRegistrationEmail(atd *models.AssignedTodo) error {
 	return err
 }
 
-func TokenAuthMiddleware() gin.HandlerFunc {
+// TokenAuthMiddleware lets requests with an access token or an API key through,
+// and keeps their AccessDetails for requestAccess.
+func (app *App) TokenAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := authentication.TokenValid(c.Request)
+		au, err := app.auth.ExtractAndFetchAccess(c.Request)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, err.Error())
 			c.Abort()
 			return
 		}
+		c.Set(accessKey, au)
+		c.Next()
+	}
+}
+
+// RequireRole lets only users with one of the roles through.  It goes after
+// TokenAuthMiddleware, and trusts the role claimed by an access token, which is
+// revoked when the role changes.
+func (app *App) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		au, err := requestAccess(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		for _, r := range roles {
+			if r == au.Role {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, "this requires the "+strings.Join(roles, " or ")+" role")
+		c.Abort()
+	}
+}
+
+// RequireScope lets only access tokens and API keys with the scope through.
+// It goes after TokenAuthMiddleware.
+func (app *App) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		au, err := requestAccess(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		if !authentication.HasScope(au.Scopes, scope) {
+			// As RFC 6750 asks of OAuth 2.0 resource servers.
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.JSON(http.StatusForbidden, "missing scope: "+scope)
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
+	"github.com/tintash-training/todo-api/app/password"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApp returns an app on a memory datastore with the user
+// alice@example.com, whose password is "secret".
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		AuthConfig: &config.AuthConfig{
+			TokenStore:          "memory",
+			AccessTokenTTL:      15,
+			ChallengeTTL:        5 * time.Minute,
+			EphemeralSigningKey: true,
+		},
+		PasswordConfig: &config.PasswordConfig{Algorithm: password.Bcrypt, BcryptCost: 4},
+		ReminderConfig: &config.ReminderConfig{},
+	}
+	db := models.NewMemoryDB()
+	auth, err := authentication.CreateAuthenticator(cfg.AuthConfig, db)
+	if err != nil {
+		t.Fatalf("CreateAuthenticator: %v", err)
+	}
+	hasher, err := password.CreateHasher(cfg.PasswordConfig)
+	if err != nil {
+		t.Fatalf("CreateHasher: %v", err)
+	}
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if err = db.CreateUser(&models.NewUser{Email: "alice@example.com", Password: hash}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	app := &App{router: gin.New(), auth: auth, hasher: hasher, config: cfg, db: db}
+	app.initRouters()
+	return app
+}
+
+// post sends body as JSON and decodes the response into result.
+func (app *App) post(t *testing.T, path string, body interface{}, result interface{}) int {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(data)))
+	if result != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+// tokenScopes returns the scopes of an access token, separated by spaces.
+func (app *App) tokenScopes(t *testing.T, accessToken string) string {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/list-tasks", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	au, err := app.auth.ExtractAndFetchAccess(r)
+	if err != nil {
+		t.Fatalf("ExtractAndFetchAccess: %v", err)
+	}
+	return strings.Join(au.Scopes, " ")
+}
+
+func TestLoginScope(t *testing.T) {
+	app := newTestApp(t)
+	for _, test := range []struct {
+		scope  string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, "tasks:read tasks:write account"},
+		{"tasks:read", http.StatusOK, "tasks:read"},
+		{" tasks:read  account ", http.StatusOK, "tasks:read account"},
+		// Scopes of other roles are left out.
+		{"tasks:read admin", http.StatusOK, "tasks:read"},
+		{"admin", http.StatusForbidden, ""},
+		{"tasks:read everything", http.StatusUnprocessableEntity, ""},
+	} {
+		var tokens map[string]string
+		status := app.post(t, "/login", credentials{Email: "alice@example.com", Password: "secret", Scope: test.scope}, &tokens)
+		if status != test.status {
+			t.Errorf("login with scope %q: got status %d, want %d", test.scope, status, test.status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if got := app.tokenScopes(t, tokens["access_token"]); got != test.want {
+			t.Errorf("login with scope %q: got scopes %q, want %q", test.scope, got, test.want)
+		}
+		// Refreshed tokens keep the scopes.
+		var refreshed map[string]string
+		if status := app.post(t, "/token/refresh", map[string]string{"refresh_token": tokens["refresh_token"]}, &refreshed); status != http.StatusOK {
+			t.Fatalf("refresh: got status %d", status)
+		}
+		if got := app.tokenScopes(t, refreshed["access_token"]); got != test.want {
+			t.Errorf("refresh with scope %q: got scopes %q, want %q", test.scope, got, test.want)
+		}
+	}
+}
+
+func TestLoginTwoFactorScope(t *testing.T) {
+	app := newTestApp(t)
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		_, err = app.db.SetTOTPSecret(1, "JBSWY3DPEHPK3PXP")
+	}
+	if err == nil {
+		_, err = app.db.EnableTOTP(1, time.Now(), hashes)
+	}
+	if err != nil {
+		t.Fatalf("enabling two-factor authentication: %v", err)
+	}
+
+	for i, test := range []struct {
+		scope, secondScope string
+		status             int
+		want               string
+	}{
+		// The scope given with the password is carried by the challenge.
+		{"tasks:read", "", http.StatusOK, "tasks:read"},
+		{"", "", http.StatusOK, "tasks:read tasks:write account"},
+		{"tasks:read", "tasks:write", http.StatusOK, "tasks:write"},
+		{"", "admin", http.StatusForbidden, ""},
+		{"", "everything", http.StatusUnprocessableEntity, ""},
+	} {
+		var challenge map[string]string
+		status := app.post(t, "/login", credentials{Email: "alice@example.com", Password: "secret", Scope: test.scope}, &challenge)
+		if status != http.StatusOK || challenge["challenge-token"] == "" {
+			t.Fatalf("login with scope %q: got status %d and %v", test.scope, status, challenge)
+		}
+		login := twoFactorLogin{ChallengeToken: challenge["challenge-token"], Scope: test.secondScope}
+		login.RecoveryCode = codes[i]
+		var tokens map[string]string
+		if status = app.post(t, "/login-2fa", login, &tokens); status != test.status {
+			t.Errorf("second step with scopes %q and %q: got status %d, want %d", test.scope, test.secondScope, status, test.status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if got := app.tokenScopes(t, tokens["access_token"]); got != test.want {
+			t.Errorf("second step with scopes %q and %q: got scopes %q, want %q", test.scope, test.secondScope, got, test.want)
+		}
+	}
+}
+
+func TestAdminScopes(t *testing.T) {
+	app := newTestApp(t)
+	if _, err := app.db.SetUserRole(1, models.UserRoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if err := app.db.CreateUser(&models.NewUser{Email: "bob@example.com"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for _, test := range []struct {
+		scope              string
+		listUsers, suspend int
+	}{
+		{"", http.StatusOK, http.StatusOK},
+		{"admin:read", http.StatusOK, http.StatusForbidden},
+		{"admin", http.StatusForbidden, http.StatusOK},
+	} {
+		var tokens map[string]string
+		if status := app.post(t, "/login", credentials{Email: "alice@example.com", Password: "secret", Scope: test.scope}, &tokens); status != http.StatusOK {
+			t.Fatalf("login with scope %q: got status %d", test.scope, status)
+		}
+		for _, request := range []struct {
+			method, path string
+			want         int
+		}{
+			{"GET", "/admin/list-users", test.listUsers},
+			{"POST", "/admin/unsuspend-user/2", test.suspend},
+		} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(request.method, request.path, nil)
+			r.Header.Set("Authorization", "Bearer "+tokens["access_token"])
+			app.router.ServeHTTP(w, r)
+			if w.Code != request.want {
+				t.Errorf("%s %s with scope %q: got status %d, want %d", request.method, request.path, test.scope, w.Code, request.want)
+			}
+		}
+	}
+}
+
+// countingDB counts the API key lookups.
+type countingDB struct {
+	models.Datastore
+	finds int
+}
+
+func (db *countingDB) FindAPIKey(hash string, now time.Time) (*models.APIKey, error) {
+	db.finds++
+	return db.Datastore.FindAPIKey(hash, now)
+}
+
+func TestAPIKeyLookedUpOnce(t *testing.T) {
+	app := newTestApp(t)
+	db := &countingDB{Datastore: app.db}
+	auth, err := authentication.CreateAuthenticator(app.config.AuthConfig, db)
+	if err != nil {
+		t.Fatalf("CreateAuthenticator: %v", err)
+	}
+	app.db, app.auth, app.router = db, auth, gin.New()
+	app.initRouters()
+
+	key, prefix, hash, err := authentication.NewAPIKey()
+	if err == nil {
+		err = db.CreateAPIKey(&models.APIKey{UserID: 1, Name: "ci", Prefix: prefix, Hash: hash,
+			Scopes: []string{authentication.ScopeTasksRead}})
+	}
+	if err != nil {
+		t.Fatalf("creating an API key: %v", err)
+	}
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/list-tasks", http.StatusOK},
+		{"/list-sessions", http.StatusForbidden},
+		{"/admin/list-users", http.StatusForbidden},
+	} {
+		db.finds = 0
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		app.router.ServeHTTP(w, r)
+		if w.Code != test.status || db.finds != 1 {
+			t.Errorf("GET %s: got status %d after %d lookups, want %d after one", test.path, w.Code, db.finds, test.status)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/twinj/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions of the tokens that are emailed to users.
+const (
+	ActionInvitation    = "invitation"
+	ActionVerifyEmail   = "verify-email"
+	ActionResetPassword = "reset-password"
+	// ActionLogin2FA is not emailed.  It lets a user who entered their
+	// password complete the login with their second factor.
+	ActionLogin2FA = "login-2fa"
+)
+
+var ErrInvalidActionToken = errors.New("invalid or expired token")
+
+// ActionDetails describes a verified action token.
+type ActionDetails struct {
+	Action     string
+	ActionUuid string
+	UserId     uint64
+	// Scopes are those granted at the first step of a login.
+	Scopes []string
+}
+
+// CreateActionToken returns a signed token that lets the user perform action
+// once before ttl has passed.
+func (auth *Auth) CreateActionToken(action string, userId uint64, ttl time.Duration) (string, error) {
+	return auth.createActionToken(action, userId, ttl, nil)
+}
+
+// CreateLoginChallenge returns an ActionLogin2FA token that remembers the
+// scopes granted with the password.
+func (auth *Auth) CreateLoginChallenge(userId uint64, scopes []string, ttl time.Duration) (string, error) {
+	return auth.createActionToken(ActionLogin2FA, userId, ttl, scopes)
+}
+
+func (auth *Auth) createActionToken(action string, userId uint64, ttl time.Duration, scopes []string) (string, error) {
+	actionUuid := uuid.NewV4().String()
+	claims := jwt.MapClaims{}
+	claims["action"] = action
+	claims["action_uuid"] = actionUuid
+	claims["user_id"] = userId
+	if scopes != nil {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(auth.actionSecret)
+	if err != nil {
+		return "", err
+	}
+
+	if err = auth.store.Set(actionKey(actionUuid), strconv.FormatUint(userId, 10), ttl); err != nil {
+		return "", err
+	}
+	// Remember the outstanding tokens of the user so that they can be revoked.
+	if err = auth.store.AddToSet(userActionsKey(action, userId), ttl, actionUuid); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyActionToken checks that token was issued for action and has been
+// neither used nor revoked.  It does not use the token up; ConsumeActionToken
+// does once the action is known to be allowed.
+func (auth *Auth) VerifyActionToken(action string, token string) (*ActionDetails, error) {
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return auth.actionSecret, nil
+	})
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid || claims["action"] != action {
+		return nil, ErrInvalidActionToken
+	}
+	actionUuid, ok := claims["action_uuid"].(string)
+	if !ok {
+		return nil, ErrInvalidActionToken
+	}
+	userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+
+	if _, err = auth.store.Get(actionKey(actionUuid)); err == ErrTokenNotFound {
+		return nil, ErrInvalidActionToken
+	} else if err != nil {
+		return nil, err
+	}
+	ad := &ActionDetails{Action: action, ActionUuid: actionUuid, UserId: userId}
+	if scope, ok := claims["scope"].(string); ok {
+		ad.Scopes = strings.Fields(scope)
+	}
+	return ad, nil
+}
+
+// ConsumeActionToken uses up a verified token.  Of two concurrent requests with
+// the same token only one succeeds.
+func (auth *Auth) ConsumeActionToken(ad *ActionDetails) error {
+	deleted, err := auth.store.Del(actionKey(ad.ActionUuid))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrInvalidActionToken
+	}
+	return nil
+}
+
+// RevokeActionTokens invalidates every outstanding token of the user for action.
+func (auth *Auth) RevokeActionTokens(action string, userId uint64) error {
+	set := userActionsKey(action, userId)
+	actionUuids, err := auth.store.SetMembers(set)
+	if err != nil {
+		return err
+	}
+	keys := []string{set}
+	for _, actionUuid := range actionUuids {
+		keys = append(keys, actionKey(actionUuid))
+	}
+	_, err = auth.store.Del(keys...)
+	return err
+}
+
+func actionKey(actionUuid string) string {
+	return "action:" + actionUuid
+}
+
+func userActionsKey(action string, userId uint64) string {
+	return "actions:" + action + ":" + strconv.FormatUint(userId, 10)
+}
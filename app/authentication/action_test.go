@@ -0,0 +1,61 @@
+package authentication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActionTokens(t *testing.T) {
+	auth := &Auth{actionSecret: []byte("secret"), store: newMemoryStore()}
+	create := func(action string, userId uint64, ttl time.Duration) string {
+		t.Helper()
+		token, err := auth.CreateActionToken(action, userId, ttl)
+		if err != nil {
+			t.Fatalf("CreateActionToken: %v", err)
+		}
+		return token
+	}
+	use := func(action string, token string) (uint64, error) {
+		ad, err := auth.VerifyActionToken(action, token)
+		if err != nil {
+			return 0, err
+		}
+		return ad.UserId, auth.ConsumeActionToken(ad)
+	}
+
+	token := create(ActionInvitation, 7, time.Hour)
+	if _, err := use("other", token); err != ErrInvalidActionToken {
+		t.Errorf("using a token for another action: got %v, want ErrInvalidActionToken", err)
+	}
+	if userId, err := use(ActionInvitation, token); err != nil || userId != 7 {
+		t.Errorf("using a token: got %d, %v, want 7", userId, err)
+	}
+	if _, err := use(ActionInvitation, token); err != ErrInvalidActionToken {
+		t.Errorf("using a token twice: got %v, want ErrInvalidActionToken", err)
+	}
+
+	expired := create(ActionInvitation, 7, -time.Minute)
+	if _, err := use(ActionInvitation, expired); err != ErrInvalidActionToken {
+		t.Errorf("using an expired token: got %v, want ErrInvalidActionToken", err)
+	}
+
+	first, second := create(ActionInvitation, 7, time.Hour), create(ActionInvitation, 7, time.Hour)
+	other := create(ActionInvitation, 8, time.Hour)
+	if err := auth.RevokeActionTokens(ActionInvitation, 7); err != nil {
+		t.Fatalf("RevokeActionTokens: %v", err)
+	}
+	for _, token := range []string{first, second} {
+		if _, err := use(ActionInvitation, token); err != ErrInvalidActionToken {
+			t.Errorf("using a revoked token: got %v, want ErrInvalidActionToken", err)
+		}
+	}
+	if _, err := use(ActionInvitation, other); err != nil {
+		t.Errorf("using the token of another user: %v", err)
+	}
+
+	forged := create(ActionInvitation, 7, time.Hour)
+	auth.actionSecret = []byte("another secret")
+	if _, err := use(ActionInvitation, forged); err != ErrInvalidActionToken {
+		t.Errorf("using a token signed with another secret: got %v, want ErrInvalidActionToken", err)
+	}
+}
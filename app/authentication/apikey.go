@@ -0,0 +1,65 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// apiKeyPrefix tells API keys apart from access tokens in the
+	// Authorization header.
+	apiKeyPrefix = "todo_"
+	// apiKeyShownLen is the length of the start of a key that is stored to
+	// tell keys apart.
+	apiKeyShownLen = len(apiKeyPrefix) + 8
+	// apiKeyTouchInterval limits how often the last use of a key is written.
+	apiKeyTouchInterval = time.Minute
+)
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewAPIKey returns a new random API key, the start of it that may be shown
+// again, and the hash to store.
+func NewAPIKey() (key string, prefix string, hash string, err error) {
+	random := make([]byte, 32)
+	if _, err = rand.Read(random); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + strings.ToLower(apiKeyEncoding.EncodeToString(random))
+	return key, key[:apiKeyShownLen], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash of a key.  Keys are random enough for a fast hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// fetchAPIKey returns the details of a key that works, and records its use.
+func (auth *Auth) fetchAPIKey(key string) (*AccessDetails, error) {
+	now := time.Now()
+	apiKey, err := auth.db.FindAPIKey(HashAPIKey(key), now)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err = auth.db.TouchAPIKey(apiKey.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	return &AccessDetails{UserId: apiKey.UserID, Role: apiKey.Role, Scopes: apiKey.Scopes, APIKeyID: apiKey.ID}, nil
+}
@@ -0,0 +1,45 @@
+package authentication
+
+import (
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	db := models.NewMemoryDB()
+	auth := &Auth{store: newMemoryStore(), db: db}
+	if err := db.CreateUser(&models.NewUser{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	if !isAPIKey(key) || len(prefix) != apiKeyShownLen || key[:apiKeyShownLen] != prefix {
+		t.Fatalf("NewAPIKey: got key %s with prefix %s", key, prefix)
+	}
+	apiKey := &models.APIKey{UserID: 1, Name: "ci", Prefix: prefix, Hash: hash,
+		Scopes: []string{ScopeTasksRead}}
+	if err = db.CreateAPIKey(apiKey); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	r, _ := http.NewRequest("GET", "/list-tasks", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	access, err := auth.ExtractAndFetchAccess(r)
+	if err != nil || access.UserId != 1 || access.APIKeyID != apiKey.ID || access.Role != models.UserRoleUser ||
+		len(access.Scopes) != 1 || access.Scopes[0] != ScopeTasksRead {
+		t.Fatalf("ExtractAndFetchAccess: got %+v, %v", access, err)
+	}
+	if keys, _ := db.GetAPIKeys(1); keys[0].LastUsedAt == nil {
+		t.Errorf("LastUsedAt was not recorded")
+	}
+
+	if _, err = db.RevokeAPIKey(1, apiKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err = auth.ExtractAndFetchAccess(r); err != ErrInvalidAPIKey {
+		t.Errorf("ExtractAndFetchAccess of a revoked key: got %v, want ErrInvalidAPIKey", err)
+	}
+}
@@ -1,49 +1,91 @@
 package authentication
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/go-redis/redis/v7"
 	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
 	"github.com/twinj/uuid"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// The token_type claim tells access and refresh tokens apart, since the same
+// keys sign both.
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrInvalidAccessToken  = errors.New("invalid access token")
+)
+
 type TokenDetails struct {
 	AccessToken  string
 	RefreshToken string
 	AccessUuid   string
 	RefreshUuid  string
-	AtExpires    int64
-	RtExpires    int64
+	// FamilyUuid identifies the chain of refresh tokens descending from one login.
+	FamilyUuid string
+	AtExpires  int64
+	RtExpires  int64
 }
 
 type Auth struct {
 	config *config.AuthConfig
-	client *redis.Client
-	//db     models.Datastore
+	store  TokenStore
+	// keys sign access and refresh tokens.
+	keys *keySet
+	// actionSecret signs action tokens.
+	actionSecret []byte
+	// db holds the API keys.
+	db models.Datastore
 }
 
-func CreateAuthenticator(config *config.AuthConfig) (*Auth, error) {
-	result := &Auth{config: config}
-	return result, result.initRedis()
+func CreateAuthenticator(config *config.AuthConfig, db models.Datastore) (*Auth, error) {
+	store, err := createTokenStore(config)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadKeySet(config.SigningKeyFile, config.EphemeralSigningKey, config.VerificationKeyFiles)
+	if err != nil {
+		return nil, err
+	}
+	actionSecret := []byte(config.ActionSecret)
+	if len(actionSecret) == 0 {
+		actionSecret = make([]byte, 32)
+		if _, err = rand.Read(actionSecret); err != nil {
+			return nil, err
+		}
+	}
+	return &Auth{config: config, store: store, keys: keys, actionSecret: actionSecret, db: db}, nil
 }
 
-func (auth *Auth) initRedis() error {
-	//Initializing redis
-	auth.client = redis.NewClient(&redis.Options{
-		Addr: auth.config.RedisDsn, //redis port
-	})
-	_, err := auth.client.Ping().Result()
-	return err
+func (auth *Auth) Ping() error {
+	return auth.store.Ping()
+}
+
+// CreateToken issues the tokens of a new session, with every scope of the
+// role.  The role of the user is carried in the claims, for RequireRole.
+func (auth *Auth) CreateToken(userid uint64, role string) (*TokenDetails, error) {
+	return auth.createToken(userid, role, RoleScopes(role), uuid.NewV4().String())
 }
 
-func (auth *Auth) CreateToken(userid uint64) (*TokenDetails, error) {
-	td := &TokenDetails{}
+// CreateScopedToken issues the tokens of a new session with the scopes that
+// GrantedScopes returned.
+func (auth *Auth) CreateScopedToken(userid uint64, role string, scopes []string) (*TokenDetails, error) {
+	return auth.createToken(userid, role, scopes, uuid.NewV4().String())
+}
+
+func (auth *Auth) createToken(userid uint64, role string, scopes []string, familyUuid string) (*TokenDetails, error) {
+	td := &TokenDetails{FamilyUuid: familyUuid}
 	td.AtExpires = time.Now().Add(time.Minute * auth.config.AccessTokenTTL).Unix()
 	td.AccessUuid = uuid.NewV4().String()
 
@@ -54,21 +96,27 @@ func (auth *Auth) CreateToken(userid uint64) (*TokenDetails, error) {
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["authorized"] = true
+	atClaims["token_type"] = tokenTypeAccess
 	atClaims["access_uuid"] = td.AccessUuid
+	atClaims["family_uuid"] = td.FamilyUuid
 	atClaims["user_id"] = userid
+	atClaims["role"] = role
+	atClaims["scope"] = strings.Join(scopes, " ")
 	atClaims["exp"] = td.AtExpires
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	td.AccessToken, err = at.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	td.AccessToken, err = auth.keys.sign(atClaims)
 	if err != nil {
 		return nil, err
 	}
 	//Creating Refresh Token
 	rtClaims := jwt.MapClaims{}
+	rtClaims["token_type"] = tokenTypeRefresh
 	rtClaims["refresh_uuid"] = td.RefreshUuid
+	rtClaims["family_uuid"] = td.FamilyUuid
 	rtClaims["user_id"] = userid
+	rtClaims["role"] = role
+	rtClaims["scope"] = strings.Join(scopes, " ")
 	rtClaims["exp"] = td.RtExpires
-	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
-	td.RefreshToken, err = rt.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
+	td.RefreshToken, err = auth.keys.sign(rtClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -80,72 +128,159 @@ func (auth *Auth) CreateAuth(userid uint64, td *TokenDetails) error {
 	rt := time.Unix(td.RtExpires, 0)
 	now := time.Now()
 
-	errAccess := auth.client.Set(td.AccessUuid, strconv.Itoa(int(userid)), at.Sub(now)).Err()
+	errAccess := auth.store.Set(td.AccessUuid, strconv.Itoa(int(userid)), at.Sub(now))
 	if errAccess != nil {
 		return errAccess
 	}
-	errRefresh := auth.client.Set(td.RefreshUuid, strconv.Itoa(int(userid)), rt.Sub(now)).Err()
+	errRefresh := auth.store.Set(td.RefreshUuid, strconv.Itoa(int(userid)), rt.Sub(now))
 	if errRefresh != nil {
 		return errRefresh
 	}
-	return nil
+	// Remember every uuid issued to the family so that it can be revoked as a whole.
+	err := auth.store.AddToSet(familyKey(td.FamilyUuid), rt.Sub(now), td.AccessUuid, td.RefreshUuid)
+	if err != nil {
+		return err
+	}
+	// And every family of the user, for RevokeUserTokens.
+	return auth.store.AddToSet(userFamiliesKey(userid), rt.Sub(now), td.FamilyUuid)
 }
 
-func extractToken(r *http.Request) string {
-	bearToken := r.Header.Get("Authorization")
-	//normally Authorization the_token_xxx
-	strArr := strings.Split(bearToken, " ")
-	if len(strArr) == 2 {
-		return strArr[1]
+func familyKey(familyUuid string) string {
+	return "family:" + familyUuid
+}
+
+func userFamiliesKey(userid uint64) string {
+	return "families:" + strconv.FormatUint(userid, 10)
+}
+
+// RevokeUserTokens logs the user out of every session.
+func (auth *Auth) RevokeUserTokens(userid uint64) error {
+	families := userFamiliesKey(userid)
+	familyUuids, err := auth.store.SetMembers(families)
+	if err != nil {
+		return err
 	}
-	return ""
+	for _, familyUuid := range familyUuids {
+		if err := auth.revokeFamily(familyUuid); err != nil {
+			return err
+		}
+	}
+	_, err = auth.store.Del(families)
+	return err
 }
 
-func verifyToken(r *http.Request) (*jwt.Token, error) {
-	tokenString := extractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		//Make sure that the token method conform to "SigningMethodHMAC"
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// Refresh exchanges a refresh token for a new access/refresh pair, with the
+// current role of the user.  The presented
+// refresh token is consumed, so each one can be used only once.  Presenting a
+// refresh token that has already been consumed indicates that it was leaked, in
+// which case every token of its family is revoked.
+func (auth *Auth) Refresh(refreshToken string, ip string, userAgent string) (*TokenDetails, error) {
+	rd, err := auth.extractRefreshMetadata(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Del is atomic in every TokenStore, so of two concurrent requests with the same token only one wins.
+	deleted, err := auth.store.Del(rd.RefreshUuid)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		if rd.FamilyUuid != "" {
+			if err := auth.revokeFamily(rd.FamilyUuid); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	// The user may have been deleted, suspended or given another role since
+	// the login.  Scopes are kept only as far as the current role allows them.
+	user, err := auth.db.ReadUserByID(rd.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.SuspendedAt != nil {
+		if rd.FamilyUuid != "" {
+			if err := auth.revokeFamily(rd.FamilyUuid); err != nil {
+				return nil, err
+			}
 		}
-		//return []byte(os.Getenv("ACCESS_SECRET")), nil
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
-	})
+		return nil, ErrInvalidRefreshToken
+	}
+
+	familyUuid := rd.FamilyUuid
+	if familyUuid == "" {
+		// Issued before token families existed.  Start a new family.
+		familyUuid = uuid.NewV4().String()
+	}
+	td, err := auth.createToken(rd.UserId, user.Role, intersectScopes(rd.Scopes, RoleScopes(user.Role)), familyUuid)
 	if err != nil {
 		return nil, err
 	}
-	return token, nil
+	err = auth.CreateAuth(rd.UserId, td)
+	if err != nil {
+		return nil, err
+	}
+	err = auth.touchSession(td, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return td, nil
 }
 
-func TokenValid(r *http.Request) error {
-	token, err := verifyToken(r)
+func (auth *Auth) revokeFamily(familyUuid string) error {
+	family := familyKey(familyUuid)
+	uuids, err := auth.store.SetMembers(family)
 	if err != nil {
 		return err
 	}
-	if _, ok := token.Claims.(jwt.Claims); !ok && !token.Valid {
-		return err
+	_, err = auth.store.Del(append(uuids, family, sessionKey(familyUuid))...)
+	return err
+}
+
+func extractToken(r *http.Request) string {
+	bearToken := r.Header.Get("Authorization")
+	//normally Authorization the_token_xxx
+	strArr := strings.Split(bearToken, " ")
+	if len(strArr) == 2 {
+		return strArr[1]
 	}
-	return nil
+	return ""
+}
+
+func (auth *Auth) verifyToken(r *http.Request) (*jwt.Token, error) {
+	return auth.keys.parse(extractToken(r))
 }
 
-func extractTokenMetadata(r *http.Request) (*AccessDetails, error) {
-	token, err := verifyToken(r)
+func (auth *Auth) extractTokenMetadata(r *http.Request) (*AccessDetails, error) {
+	token, err := auth.verifyToken(r)
 	if err != nil {
 		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if ok && token.Valid {
+		if claims["token_type"] != tokenTypeAccess {
+			return nil, ErrInvalidAccessToken
+		}
 		accessUuid, ok := claims["access_uuid"].(string)
 		if !ok {
 			return nil, err
 		}
+		// Absent from tokens issued before sessions were tracked.
+		familyUuid, _ := claims["family_uuid"].(string)
+		role := claimedRole(claims)
+		scopes := claimedScopes(claims, role)
 		userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
 		if err != nil {
 			return nil, err
 		}
 		return &AccessDetails{
 			AccessUuid: accessUuid,
+			FamilyUuid: familyUuid,
 			UserId:     userId,
+			Role:       role,
+			Scopes:     scopes,
 		}, nil
 	}
 	return nil, err
@@ -153,11 +288,63 @@ func extractTokenMetadata(r *http.Request) (*AccessDetails, error) {
 
 type AccessDetails struct {
 	AccessUuid string
+	FamilyUuid string
 	UserId     uint64
+	Role       string
+	Scopes     []string
+	// APIKeyID is the API key that authenticated the request instead of an
+	// access token, or 0.
+	APIKeyID uint64
+}
+
+type RefreshDetails struct {
+	RefreshUuid string
+	FamilyUuid  string
+	UserId      uint64
+	Role        string
+	Scopes      []string
+}
+
+// claimedRole returns the role claimed by a token.  Tokens issued before
+// roles existed are the tokens of plain users.
+func claimedRole(claims jwt.MapClaims) string {
+	if role, ok := claims["role"].(string); ok && role != "" {
+		return role
+	}
+	return "user"
+}
+
+func (auth *Auth) extractRefreshMetadata(refreshToken string) (*RefreshDetails, error) {
+	token, err := auth.keys.parse(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_type"] != tokenTypeRefresh {
+		return nil, ErrInvalidRefreshToken
+	}
+	refreshUuid, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	// Absent from tokens issued before token families existed.
+	familyUuid, _ := claims["family_uuid"].(string)
+	userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	role := claimedRole(claims)
+	return &RefreshDetails{
+		RefreshUuid: refreshUuid,
+		FamilyUuid:  familyUuid,
+		UserId:      userId,
+		Role:        role,
+		Scopes:      claimedScopes(claims, role),
+	}, nil
 }
 
 func (auth *Auth) fetchAuth(authD *AccessDetails) (uint64, error) {
-	userid, err := auth.client.Get(authD.AccessUuid).Result()
+	userid, err := auth.store.Get(authD.AccessUuid)
 	if err != nil {
 		return 0, err
 	}
@@ -166,23 +353,48 @@ func (auth *Auth) fetchAuth(authD *AccessDetails) (uint64, error) {
 }
 
 func (auth *Auth) deleteAuth(givenUuid string) error {
-	_, err := auth.client.Del(givenUuid).Result()
+	_, err := auth.store.Del(givenUuid)
 	return err
 }
 
+// ExtractAndDelAuth ends the session of the access token, so that its
+// refresh token stops working too.
 func (auth *Auth) ExtractAndDelAuth(r *http.Request) (err error) {
-	au, err := extractTokenMetadata(r)
+	au, err := auth.extractTokenMetadata(r)
 	if err != nil {
 		return
 	}
+	if au == nil {
+		return ErrInvalidAccessToken
+	}
+	if au.FamilyUuid != "" {
+		return auth.revokeFamily(au.FamilyUuid)
+	}
 	return auth.deleteAuth(au.AccessUuid)
 }
 
 func (auth *Auth) ExtractAndFetchAuth(r *http.Request) (userId uint64, err error) {
-	tokenAuth, err := extractTokenMetadata(r)
+	au, err := auth.ExtractAndFetchAccess(r)
 	if err != nil {
 		return
 	}
-	_, err = auth.fetchAuth(tokenAuth)
-	return
+	return au.UserId, nil
+}
+
+// ExtractAndFetchAccess returns the details of a valid access token or API key.
+func (auth *Auth) ExtractAndFetchAccess(r *http.Request) (*AccessDetails, error) {
+	if key := extractToken(r); isAPIKey(key) {
+		return auth.fetchAPIKey(key)
+	}
+	tokenAuth, err := auth.extractTokenMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	if tokenAuth == nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if tokenAuth.UserId, err = auth.fetchAuth(tokenAuth); err != nil {
+		return nil, err
+	}
+	return tokenAuth, nil
 }
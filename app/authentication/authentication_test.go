@@ -0,0 +1,275 @@
+package authentication
+
+import (
+	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestAuth returns an Auth kept in memory, whose users 1 and 2 exist.
+func newTestAuth(t *testing.T) *Auth {
+	t.Helper()
+	db := models.NewMemoryDB()
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		if err := db.CreateUser(&models.NewUser{Email: email}); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	return &Auth{config: &config.AuthConfig{AccessTokenTTL: 15}, store: newMemoryStore(), keys: newTestKeys(t), db: db}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	auth := newTestAuth(t)
+	login := func(userid uint64) *TokenDetails {
+		t.Helper()
+		td, err := auth.CreateToken(userid, "user")
+		if err == nil {
+			err = auth.CreateAuth(userid, td)
+		}
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		return td
+	}
+	first, second, other := login(1), login(1), login(2)
+	refreshed, err := auth.Refresh(second.RefreshToken, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	if err := auth.RevokeUserTokens(1); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+	for _, uuid := range []string{first.AccessUuid, first.RefreshUuid, refreshed.AccessUuid, refreshed.RefreshUuid} {
+		if _, err := auth.store.Get(uuid); err != ErrTokenNotFound {
+			t.Errorf("token of a revoked session: got %v, want ErrTokenNotFound", err)
+		}
+	}
+	if _, err := auth.store.Get(other.AccessUuid); err != nil {
+		t.Errorf("token of another user: %v", err)
+	}
+}
+
+func TestRefreshReuse(t *testing.T) {
+	auth := newTestAuth(t)
+	login := func() *TokenDetails {
+		t.Helper()
+		td, err := auth.CreateToken(1, "user")
+		if err == nil {
+			err = auth.CreateAuth(1, td)
+		}
+		if err == nil {
+			err = auth.StartSession(td, "phone", "10.0.0.1", "curl")
+		}
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		return td
+	}
+	first, other := login(), login()
+	second, err := auth.Refresh(first.RefreshToken, "10.0.0.1", "curl")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.FamilyUuid != first.FamilyUuid {
+		t.Errorf("Refresh started the family %s, want %s", second.FamilyUuid, first.FamilyUuid)
+	}
+	if _, err := auth.store.Get(first.RefreshUuid); err != ErrTokenNotFound {
+		t.Errorf("rotated refresh token: got %v, want ErrTokenNotFound", err)
+	}
+	if _, err := auth.store.Get(second.RefreshUuid); err != nil {
+		t.Errorf("new refresh token: %v", err)
+	}
+	if uuids, _ := auth.store.SetMembers(familyKey(first.FamilyUuid)); len(uuids) != 4 {
+		t.Errorf("family: got %q, want both pairs of tokens", uuids)
+	}
+
+	// The rotated token is presented again, by a thief or by the user.
+	if _, err := auth.Refresh(first.RefreshToken, "10.0.0.2", "curl"); err != ErrRefreshTokenReused {
+		t.Fatalf("Refresh with a rotated token: got %v, want ErrRefreshTokenReused", err)
+	}
+	for _, key := range []string{first.AccessUuid, second.AccessUuid, second.RefreshUuid, sessionKey(first.FamilyUuid)} {
+		if _, err := auth.store.Get(key); err != ErrTokenNotFound {
+			t.Errorf("%s of a reused family: got %v, want ErrTokenNotFound", key, err)
+		}
+	}
+	if uuids, _ := auth.store.SetMembers(familyKey(first.FamilyUuid)); len(uuids) != 0 {
+		t.Errorf("reused family: got %q, want none", uuids)
+	}
+	if _, err := auth.Refresh(second.RefreshToken, "10.0.0.1", "curl"); err != ErrRefreshTokenReused {
+		t.Errorf("Refresh within a revoked family: got %v, want ErrRefreshTokenReused", err)
+	}
+	for _, uuid := range []string{other.AccessUuid, other.RefreshUuid} {
+		if _, err := auth.store.Get(uuid); err != nil {
+			t.Errorf("token of another session: %v", err)
+		}
+	}
+}
+
+func TestTokenType(t *testing.T) {
+	auth := newTestAuth(t)
+	td, err := auth.CreateToken(1, "user")
+	if err == nil {
+		err = auth.CreateAuth(1, td)
+	}
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	r, _ := http.NewRequest("GET", "/list-tasks", nil)
+	r.Header.Set("Authorization", "Bearer "+td.RefreshToken)
+	if _, err := auth.ExtractAndFetchAccess(r); err == nil {
+		t.Errorf("a refresh token was accepted as an access token")
+	}
+	if _, err := auth.Refresh(td.AccessToken, "127.0.0.1", "test"); err != ErrInvalidRefreshToken {
+		t.Errorf("Refresh with an access token: got %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	auth := &Auth{store: newMemoryStore()}
+	for i := 1; i <= 4; i++ {
+		allowed, err := auth.Allow("key", 3, time.Hour)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if allowed != (i <= 3) {
+			t.Errorf("attempt %d: got %v, want %v", i, allowed, i <= 3)
+		}
+	}
+	if allowed, _ := auth.Allow("other", 3, time.Hour); !allowed {
+		t.Errorf("attempt under another key was not allowed")
+	}
+	if allowed, _ := auth.Allow("expired", 1, -time.Second); !allowed {
+		t.Errorf("first attempt was not allowed")
+	}
+	if allowed, _ := auth.Allow("expired", 1, time.Hour); !allowed {
+		t.Errorf("attempt after the window was not allowed")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	auth := newTestAuth(t)
+	login := func(device string) *TokenDetails {
+		t.Helper()
+		td, err := auth.CreateToken(1, "user")
+		if err == nil {
+			err = auth.CreateAuth(1, td)
+		}
+		if err == nil {
+			err = auth.StartSession(td, device, "10.0.0.1", "curl")
+		}
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		return td
+	}
+	phone, laptop := login("phone"), login("laptop")
+	if _, err := auth.Refresh(phone.RefreshToken, "10.0.0.2", "app"); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	sessions, err := auth.Sessions(1)
+	if err != nil {
+		t.Fatalf("Sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Device != "phone" || sessions[1].Device != "laptop" {
+		t.Fatalf("got %+v, want the phone and then the laptop", sessions)
+	}
+	if s := sessions[0]; s.ID != phone.FamilyUuid || s.IP != "10.0.0.2" || s.UserAgent != "app" {
+		t.Errorf("refreshed session: got %+v", s)
+	}
+
+	if found, err := auth.RevokeSession(2, laptop.FamilyUuid); err != nil || found {
+		t.Errorf("RevokeSession of another user: got %v, %v, want false", found, err)
+	}
+	if found, err := auth.RevokeSession(1, laptop.FamilyUuid); err != nil || !found {
+		t.Errorf("RevokeSession: got %v, %v, want true", found, err)
+	}
+	if _, err := auth.store.Get(laptop.RefreshUuid); err != ErrTokenNotFound {
+		t.Errorf("refresh token of a revoked session: got %v, want ErrTokenNotFound", err)
+	}
+	if sessions, _ = auth.Sessions(1); len(sessions) != 1 || sessions[0].ID != phone.FamilyUuid {
+		t.Errorf("after RevokeSession: got %+v, want the phone", sessions)
+	}
+	if found, _ := auth.RevokeSession(1, laptop.FamilyUuid); found {
+		t.Errorf("RevokeSession twice: got true, want false")
+	}
+}
+
+func TestRefreshCurrentRole(t *testing.T) {
+	auth := newTestAuth(t)
+	if _, err := auth.db.SetUserRole(1, models.UserRoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	td, err := auth.CreateToken(1, models.UserRoleAdmin)
+	if err == nil {
+		err = auth.CreateAuth(1, td)
+	}
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, err = auth.db.SetUserRole(1, models.UserRoleUser); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	td, err = auth.Refresh(td.RefreshToken, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	r, _ := http.NewRequest("GET", "/admin/list-users", nil)
+	r.Header.Set("Authorization", "Bearer "+td.AccessToken)
+	access, err := auth.ExtractAndFetchAccess(r)
+	if err != nil {
+		t.Fatalf("ExtractAndFetchAccess: %v", err)
+	}
+	if access.Role != models.UserRoleUser || HasScope(access.Scopes, ScopeAdmin) {
+		t.Errorf("after demotion: got role %s and scopes %q, want user without admin", access.Role, access.Scopes)
+	}
+
+	now := time.Now()
+	if _, err = auth.db.SuspendUser(1, &now); err != nil {
+		t.Fatalf("SuspendUser: %v", err)
+	}
+	if _, err = auth.Refresh(td.RefreshToken, "127.0.0.1", "test"); err != ErrInvalidRefreshToken {
+		t.Errorf("Refresh of a suspended user: got %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err = auth.store.Get(td.AccessUuid); err != ErrTokenNotFound {
+		t.Errorf("access token of a suspended user: got %v, want ErrTokenNotFound", err)
+	}
+}
+
+// Concurrent attempts must not get past the limit, as they would if the count
+// were read before it is incremented.
+func TestAllowConcurrent(t *testing.T) {
+	auth := &Auth{store: newMemoryStore()}
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := auth.Allow("2fa:1", 5, time.Hour)
+			if err != nil {
+				t.Errorf("Allow: %v", err)
+			}
+			if ok {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Errorf("got %d attempts allowed, want 5", allowed)
+	}
+
+	if err := auth.ResetLimit("2fa:1"); err != nil {
+		t.Fatalf("ResetLimit: %v", err)
+	}
+	if ok, _ := auth.Allow("2fa:1", 5, time.Hour); !ok {
+		t.Errorf("Allow after ResetLimit: got false, want true")
+	}
+}
@@ -0,0 +1,243 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"os"
+)
+
+// minRSABits is the smallest RSA key that RS256 may use, as RFC 7518 asks.
+const minRSABits = 2048
+
+var (
+	errUnknownKey   = errors.New("token signed by an unknown key")
+	errNoSigningKey = errors.New("no TODO_JWT_SIGNING_KEY given; set TODO_JWT_EPHEMERAL_KEY=true to sign with a key that is lost on restart")
+)
+
+// JWK is a public key in the JSON Web Key format of RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are the curve and the public key of Ed25519 keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type verificationKey struct {
+	jwk    JWK
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// keySet signs tokens with one key and verifies them with any of its keys,
+// which the kid header tells apart.  Keys are rotated by signing with a new
+// key while the old one keeps verifying the tokens it signed until they expire.
+type keySet struct {
+	signingKey crypto.PrivateKey
+	signing    *verificationKey
+	// keys verify tokens.  The key that signs comes first.
+	keys []*verificationKey
+}
+
+// loadKeySet reads the key that signs from a PEM file and the older keys that
+// only verify from others, which may hold public or private keys.  Without a
+// file to sign with, it signs with a new key that is lost on restart, but
+// only when ephemeral is set.
+func loadKeySet(signingKeyFile string, ephemeral bool, verificationKeyFiles []string) (*keySet, error) {
+	var signingKey crypto.PrivateKey
+	var err error
+	if signingKeyFile != "" {
+		signingKey, err = readPrivateKey(signingKeyFile)
+	} else if ephemeral {
+		_, signingKey, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		err = errNoSigningKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	publicKeys := []crypto.PublicKey{}
+	for _, file := range verificationKeyFiles {
+		publicKey, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return newKeySet(signingKey, publicKeys...)
+}
+
+func newKeySet(signingKey crypto.PrivateKey, publicKeys ...crypto.PublicKey) (*keySet, error) {
+	signer, ok := signingKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key %T", signingKey)
+	}
+	ks := &keySet{signingKey: signingKey}
+	for _, publicKey := range append([]crypto.PublicKey{signer.Public()}, publicKeys...) {
+		key, err := newVerificationKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if ks.find(key.jwk.Kid) == nil {
+			ks.keys = append(ks.keys, key)
+		}
+	}
+	ks.signing = ks.keys[0]
+	return ks, nil
+}
+
+// newVerificationKey picks the signing method of a key and names it by its
+// RFC 7638 thumbprint.
+func newVerificationKey(publicKey crypto.PublicKey) (*verificationKey, error) {
+	key := &verificationKey{public: publicKey}
+	// The members that the thumbprint hashes.
+	members := map[string]string{}
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if publicKey.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits, want at least %d", publicKey.N.BitLen(), minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+		members["kty"] = "RSA"
+		members["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		members["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		key.method = SigningMethodEdDSA
+		members["kty"] = "OKP"
+		members["crv"] = "Ed25519"
+		members["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return nil, fmt.Errorf("unsupported key %T", publicKey)
+	}
+	kid, err := thumbprint(members)
+	if err != nil {
+		return nil, err
+	}
+	key.jwk = JWK{
+		Kty: members["kty"],
+		Kid: kid,
+		Use: "sig",
+		Alg: key.method.Alg(),
+		N:   members["n"],
+		E:   members["e"],
+		Crv: members["crv"],
+		X:   members["x"],
+	}
+	return key, nil
+}
+
+// thumbprint hashes the required members of a JWK.  json.Marshal sorts them
+// and leaves out whitespace, as RFC 7638 asks.
+func thumbprint(members map[string]string) (string, error) {
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (ks *keySet) find(kid string) *verificationKey {
+	for _, key := range ks.keys {
+		if key.jwk.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func (ks *keySet) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.jwk.Kid
+	return token.SignedString(ks.signingKey)
+}
+
+// parse verifies a token with the key named by its kid header.  The algorithm
+// must be the one of the key, so that a public key is never taken for an HMAC
+// secret.
+func (ks *keySet) parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := ks.find(kid)
+		if key == nil {
+			return nil, errUnknownKey
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	})
+}
+
+// JWKS returns the public keys that verify tokens, for other services.
+func (auth *Auth) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, key := range auth.keys.keys {
+		jwks = append(jwks, key.jwk)
+	}
+	return jwks
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+	return block, nil
+}
+
+func readPrivateKey(file string) (crypto.PrivateKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("%s: unsupported PEM type %s", file, block.Type)
+}
+
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	privateKey, err := readPrivateKey(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key %T", file, privateKey)
+	}
+	return signer.Public(), nil
+}
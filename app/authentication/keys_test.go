@@ -0,0 +1,104 @@
+package authentication
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKeys(t *testing.T) *keySet {
+	t.Helper()
+	keys, err := loadKeySet("", true, nil)
+	if err != nil {
+		t.Fatalf("loadKeySet: %v", err)
+	}
+	return keys
+}
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return file
+}
+
+// The Ed25519 example of RFC 8037, appendix A.3.
+func TestThumbprint(t *testing.T) {
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	key, err := newVerificationKey(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatalf("newVerificationKey: %v", err)
+	}
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; key.jwk.Kid != want {
+		t.Errorf("kid: got %s, want %s", key.jwk.Kid, want)
+	}
+}
+
+func TestNoSigningKey(t *testing.T) {
+	if _, err := loadKeySet("", false, nil); err != errNoSigningKey {
+		t.Errorf("loadKeySet without a key: got %v, want errNoSigningKey", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPublicDER, _ := x509.MarshalPKIXPublicKey(edKey.Public())
+	rsaFile := writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	edFile := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edDER)
+	edPublicFile := writePEM(t, dir, "ed25519.pub", "PUBLIC KEY", edPublicDER)
+
+	claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+	old, err := loadKeySet(rsaFile, false, nil)
+	if err != nil {
+		t.Fatalf("loadKeySet: %v", err)
+	}
+	oldToken, err := old.sign(claims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if token, err := old.parse(oldToken); err != nil || token.Header["alg"] != "RS256" {
+		t.Fatalf("parse: got %v, %v, want an RS256 token", token, err)
+	}
+
+	// The Ed25519 key takes over and the RSA key only verifies.
+	rotated, err := loadKeySet(edFile, false, []string{rsaFile, edPublicFile})
+	if err != nil {
+		t.Fatalf("loadKeySet: %v", err)
+	}
+	if jwks := rotated.keys; len(jwks) != 2 || jwks[0].jwk.Alg != "EdDSA" || jwks[1].jwk.Alg != "RS256" {
+		t.Fatalf("keys: got %+v, want the Ed25519 and the RSA key", jwks)
+	}
+	newToken, _ := rotated.sign(claims)
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := rotated.parse(token); err != nil {
+			t.Errorf("parse after rotation: %v", err)
+		}
+	}
+	if _, err := old.parse(newToken); err == nil {
+		t.Errorf("parse of a token signed by an unknown key succeeded")
+	}
+
+	// An HMAC token keyed with the public key must not pass as the RSA key.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = old.signing.jwk.Kid
+	forgedToken, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	if _, err := rotated.parse(forgedToken); err == nil {
+		t.Errorf("parse of an HS256 token succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+package authentication
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	value   string
+	set     map[string]struct{}
+	expires time.Time
+}
+
+// memoryStore is a TokenStore for a single process.  Expired entries are
+// ignored on access and removed by a periodic sweep.
+type memoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	nextSweep time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: map[string]*memoryEntry{}}
+}
+
+func (store *memoryStore) Ping() error {
+	return nil
+}
+
+// entry returns the live entry for key.  The caller must hold mu.
+func (store *memoryStore) entry(key string, now time.Time) *memoryEntry {
+	e, ok := store.entries[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(e.expires) {
+		delete(store.entries, key)
+		return nil
+	}
+	return e
+}
+
+// sweep removes expired entries at most once per sweepInterval.  The caller must hold mu.
+func (store *memoryStore) sweep(now time.Time) {
+	if now.Before(store.nextSweep) {
+		return
+	}
+	for key, e := range store.entries {
+		if !now.Before(e.expires) {
+			delete(store.entries, key)
+		}
+	}
+	store.nextSweep = now.Add(sweepInterval)
+}
+
+func (store *memoryStore) Set(key string, value string, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	store.sweep(now)
+	store.entries[key] = &memoryEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (store *memoryStore) Get(key string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	e := store.entry(key, time.Now())
+	if e == nil || e.set != nil {
+		return "", ErrTokenNotFound
+	}
+	return e.value, nil
+}
+
+func (store *memoryStore) Del(keys ...string) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for _, key := range keys {
+		if store.entry(key, now) != nil {
+			delete(store.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (store *memoryStore) AddToSet(key string, ttl time.Duration, members ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	store.sweep(now)
+	e := store.entry(key, now)
+	if e == nil || e.set == nil {
+		e = &memoryEntry{set: map[string]struct{}{}}
+		store.entries[key] = e
+	}
+	for _, member := range members {
+		e.set[member] = struct{}{}
+	}
+	e.expires = now.Add(ttl)
+	return nil
+}
+
+func (store *memoryStore) SetMembers(key string) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	members := []string{}
+	e := store.entry(key, time.Now())
+	if e != nil {
+		for member := range e.set {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (store *memoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	store.sweep(now)
+	e := store.entry(key, now)
+	if e == nil || e.set != nil {
+		e = &memoryEntry{expires: now.Add(ttl)}
+		store.entries[key] = e
+	}
+	count, _ := strconv.ParseInt(e.value, 10, 64)
+	count++
+	e.value = strconv.FormatInt(count, 10)
+	return count, nil
+}
@@ -0,0 +1,168 @@
+package authentication
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"sync"
+	"time"
+)
+
+// The postgres store manages its own tables, so that it can live in a database
+// other than the one holding users and todos.
+const postgresSchema = `
+	CREATE TABLE IF NOT EXISTS auth_tokens (
+		key text PRIMARY KEY,
+		value text NOT NULL,
+		expires_at timestamptz NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS auth_token_sets (
+		key text,
+		member text,
+		expires_at timestamptz NOT NULL,
+		PRIMARY KEY (key, member)
+	);
+	CREATE INDEX IF NOT EXISTS idx_auth_tokens_expires_at ON auth_tokens (expires_at);
+	CREATE INDEX IF NOT EXISTS idx_auth_token_sets_expires_at ON auth_token_sets (expires_at);`
+
+// postgresStore computes expires_at with now() + make_interval(secs => ttl),
+// so that entries expire on the clock of the database they are compared with.
+type postgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+func createPostgresStore(dsn string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	store := &postgresStore{db: db}
+	if _, err = db.Exec(postgresSchema); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *postgresStore) Ping() error {
+	return store.db.Ping()
+}
+
+// sweep deletes expired rows at most once per sweepInterval.  Reads ignore
+// expired rows, so this only keeps the tables small.
+func (store *postgresStore) sweep() error {
+	store.mu.Lock()
+	now := time.Now()
+	due := !now.Before(store.nextSweep)
+	if due {
+		store.nextSweep = now.Add(sweepInterval)
+	}
+	store.mu.Unlock()
+	if !due {
+		return nil
+	}
+
+	_, err := store.db.Exec(`
+		DELETE FROM auth_tokens WHERE expires_at <= now();
+		DELETE FROM auth_token_sets WHERE expires_at <= now();`)
+	return err
+}
+
+func (store *postgresStore) Set(key string, value string, ttl time.Duration) error {
+	if err := store.sweep(); err != nil {
+		return err
+	}
+	_, err := store.db.Exec(`INSERT INTO auth_tokens (key, value, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at;`,
+		key, value, ttl.Seconds())
+	return err
+}
+
+func (store *postgresStore) Get(key string) (string, error) {
+	var value string
+	err := store.db.QueryRow("SELECT value FROM auth_tokens WHERE key = $1 AND expires_at > now();", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenNotFound
+	}
+	return value, err
+}
+
+func (store *postgresStore) Del(keys ...string) (int64, error) {
+	var deleted int64
+	err := store.db.QueryRow(`
+		WITH tokens AS (
+			DELETE FROM auth_tokens WHERE key = ANY($1) RETURNING key, expires_at
+		), sets AS (
+			DELETE FROM auth_token_sets WHERE key = ANY($1) RETURNING key, expires_at
+		)
+		SELECT count(DISTINCT key) FROM (
+			SELECT key FROM tokens WHERE expires_at > now()
+			UNION ALL
+			SELECT key FROM sets WHERE expires_at > now()
+		) AS live;`, pq.Array(keys)).Scan(&deleted)
+	return deleted, err
+}
+
+func (store *postgresStore) AddToSet(key string, ttl time.Duration, members ...string) error {
+	if err := store.sweep(); err != nil {
+		return err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// An expired set is gone, so its members must not be revived.
+	_, err = tx.Exec("DELETE FROM auth_token_sets WHERE key = $1 AND expires_at <= now();", key)
+	if err != nil {
+		return err
+	}
+	// now() is the same throughout the transaction.
+	_, err = tx.Exec(`INSERT INTO auth_token_sets (key, member, expires_at)
+		SELECT $1, unnest($2::text[]), now() + make_interval(secs => $3)
+		ON CONFLICT (key, member) DO NOTHING;`, key, pq.Array(members), ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	// Like EXPIRE in Redis, the ttl applies to the whole set.
+	_, err = tx.Exec("UPDATE auth_token_sets SET expires_at = now() + make_interval(secs => $2) WHERE key = $1;",
+		key, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *postgresStore) SetMembers(key string) ([]string, error) {
+	rows, err := store.db.Query("SELECT member FROM auth_token_sets WHERE key = $1 AND expires_at > now();", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (store *postgresStore) Incr(key string, ttl time.Duration) (int64, error) {
+	if err := store.sweep(); err != nil {
+		return 0, err
+	}
+	var count int64
+	// An expired counter starts over.
+	err := store.db.QueryRow(`INSERT INTO auth_tokens AS t (key, value, expires_at) VALUES ($1, '1', now() + make_interval(secs => $2))
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN t.expires_at > now() THEN (t.value::bigint + 1)::text ELSE '1' END,
+			expires_at = CASE WHEN t.expires_at > now() THEN t.expires_at ELSE EXCLUDED.expires_at END
+		RETURNING value::bigint;`, key, ttl.Seconds()).Scan(&count)
+	return count, err
+}
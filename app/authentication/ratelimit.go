@@ -0,0 +1,24 @@
+package authentication
+
+import "time"
+
+func rateKey(key string) string {
+	return "rate:" + key
+}
+
+// Allow counts an attempt at the action limited under key and reports whether
+// it is one of the first limit attempts of the current window.
+func (auth *Auth) Allow(key string, limit int64, window time.Duration) (bool, error) {
+	count, err := auth.store.Incr(rateKey(key), window)
+	if err != nil {
+		return false, err
+	}
+	return count <= limit, nil
+}
+
+// ResetLimit forgets the attempts counted under key, e.g. once a user got a
+// code right.
+func (auth *Auth) ResetLimit(key string) error {
+	_, err := auth.store.Del(rateKey(key))
+	return err
+}
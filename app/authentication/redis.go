@@ -0,0 +1,66 @@
+package authentication
+
+import (
+	"github.com/go-redis/redis/v7"
+	"time"
+)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func createRedisStore(dsn string) (*redisStore, error) {
+	store := &redisStore{client: redis.NewClient(&redis.Options{
+		Addr: dsn, //redis port
+	})}
+	return store, store.Ping()
+}
+
+func (store *redisStore) Ping() error {
+	return store.client.Ping().Err()
+}
+
+func (store *redisStore) Set(key string, value string, ttl time.Duration) error {
+	return store.client.Set(key, value, ttl).Err()
+}
+
+func (store *redisStore) Get(key string) (string, error) {
+	value, err := store.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return value, err
+}
+
+func (store *redisStore) Del(keys ...string) (int64, error) {
+	return store.client.Del(keys...).Result()
+}
+
+func (store *redisStore) AddToSet(key string, ttl time.Duration, members ...string) error {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	_, err := store.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SAdd(key, values...)
+		pipe.Expire(key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (store *redisStore) SetMembers(key string) ([]string, error) {
+	return store.client.SMembers(key).Result()
+}
+
+// incrScript sets the ttl of a counter when INCR creates it.
+var incrScript = redis.NewScript(`
+	local count = redis.call("INCR", KEYS[1])
+	if count == 1 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	end
+	return count`)
+
+func (store *redisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(store.client, []string{key}, ttl.Milliseconds()).Int64()
+}
@@ -0,0 +1,100 @@
+package authentication
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tintash-training/todo-api/app/models"
+	"strings"
+)
+
+// Scopes limit what an access token or API key may be used for.
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+	// ScopeAccount covers the sessions, API keys and two-factor
+	// authentication of the user.
+	ScopeAccount = "account"
+	// ScopeAdminRead covers the admin API that only looks at users and
+	// tasks, and ScopeAdmin the admin API that changes them.  Both also
+	// require a staff role.
+	ScopeAdminRead = "admin:read"
+	ScopeAdmin     = "admin"
+)
+
+var allScopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeAccount, ScopeAdminRead, ScopeAdmin}
+
+var ErrNoScopeAllowed = errors.New("none of the requested scopes is allowed")
+
+func ValidScope(scope string) bool {
+	return HasScope(allScopes, scope)
+}
+
+// RoleScopes returns every scope that the users with the role may have.
+func RoleScopes(role string) []string {
+	switch role {
+	case models.UserRoleAdmin:
+		return allScopes
+	case models.UserRoleSupport:
+		return []string{ScopeTasksRead, ScopeTasksWrite, ScopeAccount, ScopeAdminRead}
+	}
+	return []string{ScopeTasksRead, ScopeTasksWrite, ScopeAccount}
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScope splits a scope parameter, which lists scopes separated by spaces
+// like OAuth 2.0 does.  An empty parameter asks for every scope of the role,
+// which ParseScope returns as nil.
+func ParseScope(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return nil, errors.New("invalid scope: " + s)
+		}
+	}
+	return scopes, nil
+}
+
+// GrantedScopes returns the requested scopes that the role allows, or every
+// scope of the role if none were requested.
+func GrantedScopes(requested []string, role string) ([]string, error) {
+	if requested == nil {
+		return RoleScopes(role), nil
+	}
+	granted := intersectScopes(requested, RoleScopes(role))
+	if len(granted) == 0 {
+		return nil, ErrNoScopeAllowed
+	}
+	return granted, nil
+}
+
+// intersectScopes returns the scopes that are also allowed.
+func intersectScopes(scopes []string, allowed []string) []string {
+	result := []string{}
+	for _, scope := range scopes {
+		if HasScope(allowed, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result
+}
+
+// claimedScopes returns the scopes claimed by a token, which lists them
+// separated by spaces like OAuth 2.0 does.  Tokens issued before scopes
+// existed have every scope of the role.
+func claimedScopes(claims jwt.MapClaims, role string) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return RoleScopes(role)
+}
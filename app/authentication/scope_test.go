@@ -0,0 +1,62 @@
+package authentication
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenScopes(t *testing.T) {
+	auth := newTestAuth(t)
+	tokenScopes := func(token string) []string {
+		t.Helper()
+		r, _ := http.NewRequest("GET", "/list-tasks", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		au, err := auth.ExtractAndFetchAccess(r)
+		if err != nil {
+			t.Fatalf("ExtractAndFetchAccess: %v", err)
+		}
+		return au.Scopes
+	}
+	login := func(td *TokenDetails, err error) *TokenDetails {
+		t.Helper()
+		if err == nil {
+			err = auth.CreateAuth(1, td)
+		}
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		return td
+	}
+	for _, test := range []struct {
+		role             string
+		adminRead, admin bool
+	}{{"user", false, false}, {"support", true, false}, {"admin", true, true}} {
+		td := login(auth.CreateToken(1, test.role))
+		scopes := tokenScopes(td.AccessToken)
+		if !HasScope(scopes, ScopeTasksWrite) || HasScope(scopes, ScopeAdminRead) != test.adminRead || HasScope(scopes, ScopeAdmin) != test.admin {
+			t.Errorf("scopes of a %s: got %q", test.role, scopes)
+		}
+	}
+
+	td := login(auth.createToken(1, "user", []string{ScopeTasksRead}, "family"))
+	refreshed, err := auth.Refresh(td.RefreshToken, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if scopes := tokenScopes(refreshed.AccessToken); len(scopes) != 1 || scopes[0] != ScopeTasksRead {
+		t.Errorf("scopes after Refresh: got %q, want tasks:read", scopes)
+	}
+
+	// Tokens issued before scopes existed.
+	legacy, _ := auth.keys.sign(jwt.MapClaims{
+		"authorized": true, "token_type": "access", "access_uuid": "uuid", "user_id": 1, "role": "user", "exp": td.AtExpires,
+	})
+	if err := auth.store.Set("uuid", "1", time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if scopes := tokenScopes(legacy); !HasScope(scopes, ScopeTasksWrite) || HasScope(scopes, ScopeAdmin) {
+		t.Errorf("scopes of a legacy token: got %q", scopes)
+	}
+}
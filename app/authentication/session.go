@@ -0,0 +1,116 @@
+package authentication
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Session describes a login and the tokens refreshed from it, which form one
+// token family.  LastUsedAt is the time of the last login or refresh, since
+// using an access token does not touch the store.
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user-agent"`
+	CreatedAt  time.Time `json:"created-at"`
+	LastUsedAt time.Time `json:"last-used-at"`
+	// Current marks the session of the request.  It is set by the caller.
+	Current bool `json:"current,omitempty"`
+}
+
+func sessionKey(familyUuid string) string {
+	return "session:" + familyUuid
+}
+
+// StartSession records the session of the tokens of a login.
+func (auth *Auth) StartSession(td *TokenDetails, device string, ip string, userAgent string) error {
+	now := time.Now()
+	return auth.saveSession(td, &Session{
+		ID:         td.FamilyUuid,
+		Device:     device,
+		IP:         ip,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	})
+}
+
+// touchSession records that the session of td was refreshed.
+func (auth *Auth) touchSession(td *TokenDetails, ip string, userAgent string) error {
+	s, err := auth.session(td.FamilyUuid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if s == nil {
+		// Started before sessions were tracked.
+		s = &Session{ID: td.FamilyUuid, CreatedAt: now}
+	}
+	s.IP, s.UserAgent, s.LastUsedAt = ip, userAgent, now
+	return auth.saveSession(td, s)
+}
+
+// saveSession stores the session for as long as the refresh token of td lives.
+func (auth *Auth) saveSession(td *TokenDetails, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return auth.store.Set(sessionKey(s.ID), string(data), time.Until(time.Unix(td.RtExpires, 0)))
+}
+
+// session returns nil if the session has ended.
+func (auth *Auth) session(familyUuid string) (*Session, error) {
+	data, err := auth.store.Get(sessionKey(familyUuid))
+	if err == ErrTokenNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err = json.Unmarshal([]byte(data), s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sessions returns the active sessions of the user, most recently used first.
+func (auth *Auth) Sessions(userid uint64) ([]Session, error) {
+	familyUuids, err := auth.store.SetMembers(userFamiliesKey(userid))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, familyUuid := range familyUuids {
+		s, err := auth.session(familyUuid)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+// RevokeSession ends a session of the user.  It returns false if the user has
+// no such session.
+func (auth *Auth) RevokeSession(userid uint64, sessionId string) (bool, error) {
+	familyUuids, err := auth.store.SetMembers(userFamiliesKey(userid))
+	if err != nil {
+		return false, err
+	}
+	for _, familyUuid := range familyUuids {
+		if familyUuid == sessionId {
+			s, err := auth.session(familyUuid)
+			if err != nil || s == nil {
+				return false, err
+			}
+			return true, auth.revokeFamily(familyUuid)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,41 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tintash-training/todo-api/app/config"
+	"time"
+)
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore keeps the state of issued tokens.  Every entry expires after its
+// ttl.  Keys name either a string value or a set of strings, never both.
+type TokenStore interface {
+	Ping() error
+	// Set stores the value under key, replacing any previous value.
+	Set(key string, value string, ttl time.Duration) error
+	// Get returns ErrTokenNotFound if key is missing or expired.
+	Get(key string) (string, error)
+	// Del removes the keys and returns how many of them existed.
+	Del(keys ...string) (int64, error)
+	// AddToSet adds members to the set under key and resets its ttl.
+	AddToSet(key string, ttl time.Duration, members ...string) error
+	SetMembers(key string) ([]string, error)
+	// Incr adds one to the counter under key and returns the new count.  A new
+	// counter expires after ttl, which later calls do not extend.
+	Incr(key string, ttl time.Duration) (int64, error)
+}
+
+func createTokenStore(config *config.AuthConfig) (TokenStore, error) {
+	switch config.TokenStore {
+	case "redis":
+		return createRedisStore(config.RedisDsn)
+	case "memory":
+		return newMemoryStore(), nil
+	case "postgres":
+		return createPostgresStore(config.TokenStoreDsn)
+	default:
+		return nil, fmt.Errorf("unknown token store: %s", config.TokenStore)
+	}
+}
@@ -0,0 +1,128 @@
+package authentication
+
+import (
+	"github.com/tintash-training/todo-api/app/config"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testTokenStore checks that a TokenStore behaves as the interface describes.
+// newStore returns an empty store.
+func testTokenStore(t *testing.T, newStore func(t *testing.T) TokenStore) {
+	t.Run("SetGetDel", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.Get("a"); err != ErrTokenNotFound {
+			t.Errorf("Get of a missing key: got %v, want ErrTokenNotFound", err)
+		}
+		if err := store.Set("a", "1", time.Hour); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := store.Set("a", "2", time.Hour); err != nil {
+			t.Fatalf("Set again: %v", err)
+		}
+		if value, err := store.Get("a"); err != nil || value != "2" {
+			t.Errorf("Get: got %q, %v, want 2", value, err)
+		}
+		if err := store.Set("expired", "1", -time.Second); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if _, err := store.Get("expired"); err != ErrTokenNotFound {
+			t.Errorf("Get of an expired key: got %v, want ErrTokenNotFound", err)
+		}
+		if err := store.AddToSet("set", time.Hour, "x"); err != nil {
+			t.Fatalf("AddToSet: %v", err)
+		}
+		if deleted, err := store.Del("a", "set", "expired", "missing"); err != nil || deleted != 2 {
+			t.Errorf("Del: got %d, %v, want 2", deleted, err)
+		}
+		if _, err := store.Get("a"); err != ErrTokenNotFound {
+			t.Errorf("Get of a deleted key: got %v, want ErrTokenNotFound", err)
+		}
+		if deleted, err := store.Del("a"); err != nil || deleted != 0 {
+			t.Errorf("Del twice: got %d, %v, want 0", deleted, err)
+		}
+	})
+
+	t.Run("Sets", func(t *testing.T) {
+		store := newStore(t)
+		members := func(key string) []string {
+			t.Helper()
+			members, err := store.SetMembers(key)
+			if err != nil {
+				t.Fatalf("SetMembers: %v", err)
+			}
+			sort.Strings(members)
+			return members
+		}
+		if got := members("set"); len(got) != 0 {
+			t.Errorf("SetMembers of a missing set: got %q", got)
+		}
+		if err := store.AddToSet("set", time.Hour, "b", "a"); err != nil {
+			t.Fatalf("AddToSet: %v", err)
+		}
+		if err := store.AddToSet("set", time.Hour, "a", "c"); err != nil {
+			t.Fatalf("AddToSet: %v", err)
+		}
+		if got := members("set"); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+			t.Errorf("SetMembers: got %q, want a, b and c", got)
+		}
+
+		// The ttl applies to the whole set, and an expired set starts empty.
+		if err := store.AddToSet("set", -time.Second, "d"); err != nil {
+			t.Fatalf("AddToSet: %v", err)
+		}
+		if got := members("set"); len(got) != 0 {
+			t.Errorf("SetMembers of an expired set: got %q", got)
+		}
+		if err := store.AddToSet("set", time.Hour, "e"); err != nil {
+			t.Fatalf("AddToSet: %v", err)
+		}
+		if got := members("set"); len(got) != 1 || got[0] != "e" {
+			t.Errorf("SetMembers after expiry: got %q, want e", got)
+		}
+	})
+
+	t.Run("Incr", func(t *testing.T) {
+		store := newStore(t)
+		for i := int64(1); i <= 3; i++ {
+			count, err := store.Incr("counter", time.Hour)
+			if err != nil || count != i {
+				t.Errorf("Incr: got %d, %v, want %d", count, err, i)
+			}
+		}
+		if count, err := store.Incr("expired", -time.Second); err != nil || count != 1 {
+			t.Errorf("Incr: got %d, %v, want 1", count, err)
+		}
+		if count, err := store.Incr("expired", time.Hour); err != nil || count != 1 {
+			t.Errorf("Incr of an expired counter: got %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testTokenStore(t, func(t *testing.T) TokenStore {
+		return newMemoryStore()
+	})
+}
+
+// The postgres store is only tested when TODO_TEST_POSTGRES is set.  Its tables
+// are emptied before every test.
+func TestPostgresStore(t *testing.T) {
+	if os.Getenv("TODO_TEST_POSTGRES") == "" {
+		t.Skip("TODO_TEST_POSTGRES not set")
+	}
+	dsn := config.GetConf().AuthConfig.TokenStoreDsn
+	testTokenStore(t, func(t *testing.T) TokenStore {
+		store, err := createPostgresStore(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.db.Close() })
+		if _, err = store.db.Exec("TRUNCATE auth_tokens, auth_token_sets;"); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
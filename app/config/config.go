@@ -1,21 +1,73 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	AuthConfig *AuthConfig
-	DBConfig   *DBConfig
-	SMTPConfig *SMTPConfig
+	AuthConfig     *AuthConfig
+	PasswordConfig *PasswordConfig
+	DBConfig       *DBConfig
+	SMTPConfig     *SMTPConfig
+	ReminderConfig *ReminderConfig
 }
 
 type AuthConfig struct {
+	// TokenStore is one of "redis", "memory" or "postgres".
+	TokenStore      string
 	RedisDsn        string
+	TokenStoreDsn   string
 	AccessTokenTTL  time.Duration
 	RefreshTokenTTL time.Duration
+	// SigningKeyFile is a PEM file with the RSA or Ed25519 private key that
+	// signs access and refresh tokens.  It is required unless
+	// EphemeralSigningKey is set.
+	SigningKeyFile string
+	// EphemeralSigningKey lets a development server start without a
+	// SigningKeyFile.  A new key is then made on every start, which logs
+	// everyone out.
+	EphemeralSigningKey bool
+	// VerificationKeyFiles are PEM files with keys that only verify tokens.
+	// To rotate keys, sign with a new key and keep the old one here until
+	// the tokens it signed have expired.
+	VerificationKeyFiles []string
+	// ActionSecret signs the single-use tokens that are emailed to users.
+	// Without one, a random secret is made on every start, which voids the
+	// links that were emailed before.
+	ActionSecret string
+	// InvitationTTL is how long the invitation of a pending user stays valid.
+	InvitationTTL time.Duration
+	// VerificationTTL is how long an email verification link stays valid.
+	VerificationTTL time.Duration
+	// RequireVerifiedEmail keeps users from logging in until they have
+	// verified their email address.
+	RequireVerifiedEmail bool
+	// PublicURL is where users reach the API, for the links emailed to them.
+	PublicURL string
+	// PasswordResetTTL is how long a password reset token stays valid.
+	PasswordResetTTL time.Duration
+	// Password resets allowed for an email address, and from a client IP, in
+	// every ResetRateWindow.
+	ResetEmailLimit int
+	ResetIPLimit    int
+	ResetRateWindow time.Duration
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer string
+	// ChallengeTTL is how long users have to enter their second factor.
+	ChallengeTTL time.Duration
+}
+
+type PasswordConfig struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2id parameters.  Memory is in KiB.
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
 }
 
 type DBConfig struct {
@@ -27,6 +79,11 @@ type DBConfig struct {
 	Host     string
 	Port     int
 	SSLMode  string
+	// Connection pool settings.  Zero means no limit.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
 type SMTPConfig struct {
@@ -38,6 +95,21 @@ type SMTPConfig struct {
 	InsecureSkipVerify bool
 }
 
+type ReminderConfig struct {
+	// Interval between checks for due reminders.  Zero disables reminder emails.
+	Interval time.Duration
+}
+
+func (config *DBConfig) DataSourceName() string {
+	return fmt.Sprintf("host=%s user=%s dbname=%s sslmode=%s port=%d password=%s",
+		config.Host,
+		config.Username,
+		config.Name,
+		config.SSLMode,
+		config.Port,
+		config.Password)
+}
+
 func getenv(key, fallback string) string {
 	if value := os.Getenv(key); len(value) != 0 {
 		return value
@@ -55,24 +127,83 @@ func getenvInt(key string, fallback int) int {
 	return fallback
 }
 
+func getenvBool(key string, fallback bool) bool {
+	if value := os.Getenv(key); len(value) != 0 {
+		result, err := strconv.ParseBool(value)
+		if err == nil {
+			return result
+		}
+	}
+	return fallback
+}
+
+// getenvList splits a comma-separated list.
+func getenvList(key string) []string {
+	list := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); len(value) != 0 {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); len(value) != 0 {
+		result, err := time.ParseDuration(value)
+		if err == nil {
+			return result
+		}
+	}
+	return fallback
+}
+
 func GetConf() *Config {
-	os.Setenv("ACCESS_SECRET", "jdnfksdmfksd")         //this should be in an env file
-	os.Setenv("REFRESH_SECRET", "mcmvmkmsdnfsdmfdsjf") //this should be in an env file
+	dbConfig := &DBConfig{
+		Impl:     getenv("TODO_DB_IMPL", "gorm"),
+		Dialect:  getenv("TODO_DB_DIALECT", "postgres"),
+		Name:     getenv("TODO_DB_NAME", "todo"),
+		Username: getenv("TODO_DB_USERNAME", "postgres"),
+		Password: getenv("TODO_DB_PASSWORD", "password"),
+		Host:     getenv("TODO_DB_HOST", "localhost"),
+		Port:     getenvInt("TODO_DB_PORT", 55000),
+		SSLMode:  getenv("TODO_DB_SSLMODE", "disable"),
+
+		MaxOpenConns:    getenvInt("TODO_DB_MAX_OPEN_CONNS", 20),
+		MaxIdleConns:    getenvInt("TODO_DB_MAX_IDLE_CONNS", 10),
+		ConnMaxLifetime: getenvDuration("TODO_DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: getenvDuration("TODO_DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+	}
 	return &Config{
 		AuthConfig: &AuthConfig{
-			RedisDsn:        getenv("REDIS_DSN", "localhost:6379"),
-			AccessTokenTTL:  15 * 60,
-			RefreshTokenTTL: 60 * 24},
-		DBConfig: &DBConfig{
-			Impl:     getenv("TODO_DB_IMPL", "gorm"),
-			Dialect:  getenv("TODO_DB_DIALECT", "postgres"),
-			Name:     getenv("TODO_DB_NAME", "todo"),
-			Username: getenv("TODO_DB_USERNAME", "postgres"),
-			Password: getenv("TODO_DB_PASSWORD", "password"),
-			Host:     getenv("TODO_DB_HOST", "localhost"),
-			Port:     getenvInt("TODO_DB_PORT", 55000),
-			SSLMode:  getenv("TODO_DB_SSLMODE", "disable"),
+			TokenStore:           getenv("TODO_TOKEN_STORE", "redis"),
+			RedisDsn:             getenv("REDIS_DSN", "localhost:6379"),
+			TokenStoreDsn:        getenv("TODO_TOKEN_STORE_DSN", dbConfig.DataSourceName()),
+			AccessTokenTTL:       15 * 60,
+			RefreshTokenTTL:      60 * 24,
+			SigningKeyFile:       getenv("TODO_JWT_SIGNING_KEY", ""),
+			EphemeralSigningKey:  getenvBool("TODO_JWT_EPHEMERAL_KEY", false),
+			VerificationKeyFiles: getenvList("TODO_JWT_VERIFICATION_KEYS"),
+			ActionSecret:         getenv("TODO_ACTION_SECRET", ""),
+			InvitationTTL:        getenvDuration("TODO_INVITATION_TTL", 7*24*time.Hour),
+			VerificationTTL:      getenvDuration("TODO_VERIFICATION_TTL", 24*time.Hour),
+			RequireVerifiedEmail: getenvBool("TODO_REQUIRE_VERIFIED_EMAIL", true),
+			PublicURL:            getenv("TODO_PUBLIC_URL", "http://localhost:8080"),
+			PasswordResetTTL:     getenvDuration("TODO_PASSWORD_RESET_TTL", 30*time.Minute),
+			ResetEmailLimit:      getenvInt("TODO_RESET_EMAIL_LIMIT", 3),
+			ResetIPLimit:         getenvInt("TODO_RESET_IP_LIMIT", 20),
+			ResetRateWindow:      getenvDuration("TODO_RESET_RATE_WINDOW", time.Hour),
+			TOTPIssuer:           getenv("TODO_TOTP_ISSUER", "todo-api"),
+			ChallengeTTL:         getenvDuration("TODO_2FA_CHALLENGE_TTL", 5*time.Minute),
+		},
+		PasswordConfig: &PasswordConfig{
+			Algorithm:     getenv("TODO_PASSWORD_HASH", "bcrypt"),
+			BcryptCost:    getenvInt("TODO_BCRYPT_COST", 12),
+			Argon2Time:    getenvInt("TODO_ARGON2_TIME", 1),
+			Argon2Memory:  getenvInt("TODO_ARGON2_MEMORY", 64*1024),
+			Argon2Threads: getenvInt("TODO_ARGON2_THREADS", 4),
 		},
+		DBConfig: dbConfig,
 		SMTPConfig: &SMTPConfig{
 			Username:           getenv("TODO_SMTP_USERNAME", "test@google.com"),
 			Password:           getenv("TODO_SMTP_PASSWORD", "password"),
@@ -81,5 +212,8 @@ func GetConf() *Config {
 			Port:               getenvInt("TODO_SMTP_PORT", 25),
 			InsecureSkipVerify: false,
 		},
+		ReminderConfig: &ReminderConfig{
+			Interval: getenvDuration("TODO_REMINDER_INTERVAL", time.Minute),
+		},
 	}
 }
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+)
+
+// invitee names the pending user of an invitation.
+type invitee struct {
+	Email string `json:"email"`
+}
+
+// sendRegistrationEmail emails a pending user a new invitation, which is needed
+// to complete the registration of the account.
+func (app *App) sendRegistrationEmail(user *models.User, subject string, body string) error {
+	ttl := app.config.AuthConfig.InvitationTTL
+	token, err := app.auth.CreateActionToken(authentication.ActionInvitation, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	body = fmt.Sprintf("%s\n\nRegister with this invitation within %s to see it:\n\n%s\n", body, ttl, token)
+	return app.sendEmail(user.Email, subject, body)
+}
+
+// useInvitation checks that token invites the pending user and uses it up.
+func (app *App) useInvitation(user *models.User, token string) error {
+	ad, err := app.auth.VerifyActionToken(authentication.ActionInvitation, token)
+	if err != nil {
+		return err
+	}
+	if ad.UserId != user.ID {
+		return authentication.ErrInvalidActionToken
+	}
+	return app.auth.ConsumeActionToken(ad)
+}
+
+// invitedUser returns the pending user of the request, who must have been
+// invited by the authenticated user.  It answers the request itself otherwise.
+func (app *App) invitedUser(c *gin.Context) (*models.User, bool) {
+	var inv invitee
+	if err := c.ShouldBindJSON(&inv); err != nil || inv.Email == "" {
+		c.JSON(http.StatusUnprocessableEntity, "email is required")
+		return nil, false
+	}
+	inviterId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	user, err := app.db.ReadUser(inv.Email)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil || !*user.Pending {
+		c.JSON(http.StatusNotFound, "no pending invitation for "+inv.Email)
+		return nil, false
+	}
+	invited, err := app.db.HasInvited(inviterId, user.ID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !invited {
+		// Not disclosing who else has been invited.
+		c.JSON(http.StatusNotFound, "no pending invitation for "+inv.Email)
+		return nil, false
+	}
+	return user, true
+}
+
+// ResendInvitation replaces the invitations of a pending user with a new one.
+// It can be called by anyone who has assigned a task to the user or shared a
+// list with them.
+func (app *App) ResendInvitation(c *gin.Context) {
+	user, ok := app.invitedUser(c)
+	if !ok {
+		return
+	}
+	if err := app.auth.RevokeActionTokens(authentication.ActionInvitation, user.ID); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	err := app.sendRegistrationEmail(user, "You have been invited.", "You have been invited to share tasks.")
+	if err != nil {
+		glog.Error("Error sending email:", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "invitation sent")
+}
+
+// RevokeInvitation invalidates every outstanding invitation of a pending user,
+// whoever sent it.  The user cannot register until invited again.
+func (app *App) RevokeInvitation(c *gin.Context) {
+	user, ok := app.invitedUser(c)
+	if !ok {
+		return
+	}
+	if err := app.auth.RevokeActionTokens(authentication.ActionInvitation, user.ID); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "invitation revoked")
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"strconv"
+)
+
+// listIdParam parses the list-id path parameter.  It answers the request itself
+// when the parameter is invalid.
+func listIdParam(c *gin.Context) (uint64, bool) {
+	listId, err := strconv.ParseUint(c.Param("list-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid list-id")
+		return 0, false
+	}
+	return listId, true
+}
+
+// respondListRows answers a request that changed rows lists with status.
+func respondListRows(c *gin.Context, rows int64, status int) {
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "list not found")
+	case 1:
+		c.Status(status)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
+
+func (app *App) CreateList(c *gin.Context) {
+	var nl models.NewList
+	if err := c.ShouldBindJSON(&nl); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	if err := nl.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if nl.Name == "" {
+		c.JSON(http.StatusUnprocessableEntity, "name is required")
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	list := models.List{NewList: nl, UserID: userId}
+	if err = app.db.CreateList(&list); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"list-id": list.ID})
+}
+
+// GetLists lists the lists of the user.  Archived lists are included when the
+// archived parameter is true.
+func (app *App) GetLists(c *gin.Context) {
+	archived, err := strconv.ParseBool(c.DefaultQuery("archived", "false"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid archived")
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	lists, err := app.db.GetLists(userId, archived)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, lists)
+}
+
+func (app *App) UpdateList(c *gin.Context) {
+	var nl models.NewList
+	if err := c.ShouldBindJSON(&nl); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	if err := nl.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, ok := app.requireListRole(c, userId, listId, models.RoleOwner); !ok {
+		return
+	}
+
+	rows, err := app.db.UpdateList(&models.List{ID: listId, NewList: nl, UserID: userId})
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	respondListRows(c, rows, http.StatusCreated)
+}
+
+func (app *App) ArchiveList(c *gin.Context) {
+	app.setListArchived(c, true)
+}
+
+func (app *App) UnarchiveList(c *gin.Context) {
+	app.setListArchived(c, false)
+}
+
+func (app *App) setListArchived(c *gin.Context, archived bool) {
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, ok := app.requireListRole(c, userId, listId, models.RoleOwner); !ok {
+		return
+	}
+
+	rows, err := app.db.ArchiveList(userId, listId, archived)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	respondListRows(c, rows, http.StatusOK)
+}
+
+// DeleteList deletes a list.  Its tasks are kept and no longer belong to a list.
+func (app *App) DeleteList(c *gin.Context) {
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, ok := app.requireListRole(c, userId, listId, models.RoleOwner); !ok {
+		return
+	}
+
+	rows, err := app.db.DeleteList(userId, listId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	respondListRows(c, rows, http.StatusCreated)
+}
+
+// taskList names the list a task is moved to.  A missing or zero list-id
+// takes the task out of its list.
+type taskList struct {
+	ListID uint64 `json:"list-id"`
+}
+
+func (app *App) MoveTodo(c *gin.Context) {
+	taskId, err := strconv.ParseUint(c.Param("task-id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "no valid task-id")
+		return
+	}
+	var target taskList
+	if err := c.ShouldBindJSON(&target); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if !app.requireTaskRole(c, userId, taskId, models.RoleEditor) {
+		return
+	}
+	if target.ListID != 0 {
+		if _, ok := app.requireListRole(c, userId, target.ListID, models.RoleEditor); !ok {
+			return
+		}
+	}
+
+	rows, err := app.db.SetToDoList(userId, taskId, target.ListID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	switch rows {
+	case 0:
+		c.JSON(http.StatusNotFound, "task or list not found")
+	case 1:
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusInternalServerError)
+		glog.Error("should not happen")
+	}
+}
+
+// ShareList shares a list with the user registered with the email, who gets the
+// role.  Sharing again changes the role.  Unknown users are invited to register.
+func (app *App) ShareList(c *gin.Context) {
+	var share models.ListShare
+	if err := c.ShouldBindJSON(&share); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	if err := share.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	list, ok := app.requireListRole(c, userId, listId, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	member, err := app.lookupOrInvite(share.Email, "A list has been shared with you.", list.Name)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if member.ID == list.UserID {
+		c.JSON(http.StatusUnprocessableEntity, "the list belongs to "+share.Email)
+		return
+	}
+	if err = app.db.ShareList(listId, member.ID, share.Role); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+// UnshareList removes a member from a list.  Owners can remove anyone, and
+// every member can leave.
+func (app *App) UnshareList(c *gin.Context) {
+	var share models.ListShare
+	if err := c.ShouldBindJSON(&share); err != nil || share.Email == "" {
+		c.JSON(http.StatusUnprocessableEntity, "invalid json")
+		return
+	}
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	member, err := app.db.ReadUser(share.Email)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	min := models.RoleOwner
+	if member != nil && member.ID == userId {
+		min = models.RoleViewer
+	}
+	if _, ok := app.requireListRole(c, userId, listId, min); !ok {
+		return
+	}
+
+	var rows int64
+	if member != nil {
+		rows, err = app.db.UnshareList(listId, member.ID)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, "member not found")
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// GetListMembers lists the owner and the members of a list.
+func (app *App) GetListMembers(c *gin.Context) {
+	listId, ok := listIdParam(c)
+	if !ok {
+		return
+	}
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if _, ok := app.requireListRole(c, userId, listId, models.RoleViewer); !ok {
+		return
+	}
+
+	members, err := app.db.GetListMembers(listId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
@@ -0,0 +1,134 @@
+package models_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/tintash-training/todo-api/app/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingDriver is a database/sql driver that records the statements it is
+// given.  Queries return no row, except for positions, which are all "i".
+type recordingDriver struct {
+	statements *[]string
+}
+
+func (d recordingDriver) Open(string) (driver.Conn, error) { return d, nil }
+func (d recordingDriver) Close() error                     { return nil }
+func (d recordingDriver) Begin() (driver.Tx, error)        { return d, nil }
+func (d recordingDriver) Commit() error                    { return nil }
+func (d recordingDriver) Rollback() error                  { return nil }
+
+func (d recordingDriver) Prepare(query string) (driver.Stmt, error) {
+	*d.statements = append(*d.statements, query)
+	return recordedStmt{query}, nil
+}
+
+type recordedStmt struct {
+	query string
+}
+
+func (s recordedStmt) Close() error  { return nil }
+func (s recordedStmt) NumInput() int { return -1 }
+
+func (s recordedStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s recordedStmt) Query([]driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "SELECT position") {
+		return &recordedRows{columns: []string{"position"}, values: []driver.Value{"i"}}, nil
+	}
+	return &recordedRows{}, nil
+}
+
+type recordedRows struct {
+	columns []string
+	values  []driver.Value
+}
+
+func (r *recordedRows) Columns() []string { return r.columns }
+func (r *recordedRows) Close() error      { return nil }
+
+func (r *recordedRows) Next(dest []driver.Value) error {
+	if r.values == nil {
+		return io.EOF
+	}
+	copy(dest, r.values)
+	r.values = nil
+	return nil
+}
+
+// TestGormBindings checks, without a database, that GormDB binds every
+// argument of its queries.  GORM takes a []interface{} passed without ... as
+// the value of the first placeholder, and leaves the others unbound.
+func TestGormBindings(t *testing.T) {
+	var statements []string
+	sql.Register("recording", recordingDriver{&statements})
+	conn, err := sql.Open("recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &models.GormDB{DB: g}
+
+	now := time.Now()
+	calls := map[string]func() error{
+		"SaveToDo": func() error { return db.SaveToDo(&models.Todo{NewTodo: models.NewTodo{Title: "a"}, UserID: 1}) },
+		"UpdateToDo": func() error {
+			_, err := db.UpdateToDo(&models.Todo{ID: 1, NewTodo: models.NewTodo{Title: "a"}, UserID: 1})
+			return err
+		},
+		"DeleteToDo": func() error { _, err := db.DeleteToDo(1, 2); return err },
+		"TaskRole":   func() error { _, err := db.TaskRole(1, 2); return err },
+		"MoveToDo":   func() error { _, err := db.MoveToDo(1, 2, 3, true); return err },
+		"GetAllTasks": func() error {
+			_, err := db.GetAllTasks(1, models.TaskQuery{Sort: models.SortPosition, Limit: 10,
+				TaskFilter: models.TaskFilter{Text: "milk", Unfinished: true}})
+			return err
+		},
+		"SearchTasks": func() error { _, err := db.SearchTasks(1, "milk", 10); return err },
+		"SetToDoList": func() error { _, err := db.SetToDoList(1, 2, 3); return err },
+		"SetToDoList out of a list": func() error {
+			_, err := db.SetToDoList(1, 2, 0)
+			return err
+		},
+		"GetList":        func() error { _, err := db.GetList(1, 3); return err },
+		"GetLists":       func() error { _, err := db.GetLists(1, true); return err },
+		"ArchiveList":    func() error { _, err := db.ArchiveList(1, 3, true); return err },
+		"DeleteList":     func() error { _, err := db.DeleteList(1, 3); return err },
+		"ShareList":      func() error { return db.ShareList(3, 2, models.RoleEditor) },
+		"GetListMembers": func() error { _, err := db.GetListMembers(3); return err },
+		"HasInvited":     func() error { _, err := db.HasInvited(1, 2); return err },
+		"DueReminders":   func() error { _, err := db.DueReminders(now); return err },
+		"GetUsers": func() error {
+			_, err := db.GetUsers(models.UserFilter{Text: "a", Role: models.UserRoleUser, AfterID: 1, Limit: 10})
+			return err
+		},
+		"GetAPIKeys": func() error { _, err := db.GetAPIKeys(1); return err },
+		"FindAPIKey": func() error { _, err := db.FindAPIKey("hash", now); return err },
+	}
+	for name, call := range calls {
+		statements = nil
+		if err := call(); err != nil && err != sql.ErrNoRows {
+			t.Errorf("%s: %v", name, err)
+		}
+		if len(statements) == 0 {
+			t.Errorf("%s ran no statement", name)
+		}
+		for _, statement := range statements {
+			if strings.Contains(statement, "?") {
+				t.Errorf("%s left a placeholder unbound: %s", name, statement)
+			}
+		}
+	}
+}
@@ -0,0 +1,1248 @@
+// Package datastoretest provides a conformance suite for models.Datastore
+// implementations, so that every backend can be shown to behave the same.
+package datastoretest
+
+import (
+	"fmt"
+	"github.com/tintash-training/todo-api/app/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run runs every conformance test against the Datastore returned by open.
+// open is called once per test and must return an empty Datastore.
+func Run(t *testing.T, open func(t *testing.T) models.Datastore) {
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, open(t))
+		})
+	}
+}
+
+var tests = []struct {
+	name string
+	run  func(t *testing.T, ds models.Datastore)
+}{
+	{"ReadUserUnknown", testReadUserUnknown},
+	{"CreateAndReadUser", testCreateAndReadUser},
+	{"CreateUserDuplicate", testCreateUserDuplicate},
+	{"PendingUserUpgrade", testPendingUserUpgrade},
+	{"UpdatePassword", testUpdatePassword},
+	{"VerifyUser", testVerifyUser},
+	{"SaveToDo", testSaveToDo},
+	{"UpdateToDo", testUpdateToDo},
+	{"DeleteToDo", testDeleteToDo},
+	{"GetAllTasksIsolation", testGetAllTasksIsolation},
+	{"TaskStatus", testTaskStatus},
+	{"GetAllTasksByStatus", testGetAllTasksByStatus},
+	{"GetAllTasksByDueDate", testGetAllTasksByDueDate},
+	{"Reminders", testReminders},
+	{"ClearDueAt", testClearDueAt},
+	{"GetAllTasksFilters", testGetAllTasksFilters},
+	{"GetAllTasksPages", testGetAllTasksPages},
+	{"GetAllTasksSortByDue", testGetAllTasksSortByDue},
+	{"SearchTasks", testSearchTasks},
+	{"Priority", testPriority},
+	{"MoveToDo", testMoveToDo},
+	{"MoveToDoTies", testMoveToDoTies},
+	{"Lists", testLists},
+	{"TaskLists", testTaskLists},
+	{"SharedLists", testSharedLists},
+	{"Assignments", testAssignments},
+	{"HasInvited", testHasInvited},
+	{"AdminUsers", testAdminUsers},
+	{"GetToDo", testGetToDo},
+	{"TwoFactor", testTwoFactor},
+	{"APIKeys", testAPIKeys},
+}
+
+func createUser(t *testing.T, ds models.Datastore, email string) *models.User {
+	t.Helper()
+	if err := ds.CreateUser(&models.NewUser{Email: email, Password: "secret"}); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return readUser(t, ds, email)
+}
+
+func readUser(t *testing.T, ds models.Datastore, email string) *models.User {
+	t.Helper()
+	user, err := ds.ReadUser(email)
+	if err != nil {
+		t.Fatalf("ReadUser(%s): %v", email, err)
+	}
+	if user == nil {
+		t.Fatalf("ReadUser(%s): user not found", email)
+	}
+	return user
+}
+
+func saveToDo(t *testing.T, ds models.Datastore, userId uint64, title string) *models.Todo {
+	t.Helper()
+	td := &models.Todo{NewTodo: models.NewTodo{Title: title}, UserID: userId}
+	if err := ds.SaveToDo(td); err != nil {
+		t.Fatalf("SaveToDo(%s): %v", title, err)
+	}
+	return td
+}
+
+func getAllTasks(t *testing.T, ds models.Datastore, userId uint64) []models.Todo {
+	t.Helper()
+	return filterTasks(t, ds, userId, models.TaskFilter{})
+}
+
+func filterTasks(t *testing.T, ds models.Datastore, userId uint64, filter models.TaskFilter) []models.Todo {
+	t.Helper()
+	return queryTasks(t, ds, userId, models.TaskQuery{TaskFilter: filter}).Tasks
+}
+
+func queryTasks(t *testing.T, ds models.Datastore, userId uint64, query models.TaskQuery) *models.TaskPage {
+	t.Helper()
+	page, err := ds.GetAllTasks(userId, query)
+	if err != nil {
+		t.Fatalf("GetAllTasks(%d): %v", userId, err)
+	}
+	return page
+}
+
+// allPages follows the page tokens from the first page to the last.
+func allPages(t *testing.T, ds models.Datastore, userId uint64, query models.TaskQuery) []models.Todo {
+	t.Helper()
+	todos := []models.Todo{}
+	for i := 0; ; i++ {
+		page := queryTasks(t, ds, userId, query)
+		todos = append(todos, page.Tasks...)
+		if query.Limit > 0 && len(page.Tasks) > query.Limit {
+			t.Fatalf("got %d tasks on a page, want at most %d", len(page.Tasks), query.Limit)
+		}
+		if page.NextPage == "" {
+			return todos
+		}
+		if i > 100 {
+			t.Fatal("too many pages")
+		}
+		cursor, err := models.DecodeTaskCursor(page.NextPage)
+		if err != nil {
+			t.Fatalf("DecodeTaskCursor(%s): %v", page.NextPage, err)
+		}
+		query.Cursor = cursor
+	}
+}
+
+func titles(todos []models.Todo) []string {
+	result := []string{}
+	for _, td := range todos {
+		result = append(result, td.Title)
+	}
+	return result
+}
+
+func expectTitles(t *testing.T, todos []models.Todo, want ...string) {
+	t.Helper()
+	got := titles(todos)
+	if len(got) != len(want) {
+		t.Fatalf("got tasks %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got tasks %q, want %q", got, want)
+		}
+	}
+}
+
+func expectRows(t *testing.T, op string, rows int64, err error, want int64) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", op, err)
+	}
+	if rows != want {
+		t.Fatalf("%s: got %d rows, want %d", op, rows, want)
+	}
+}
+
+func testReadUserUnknown(t *testing.T, ds models.Datastore) {
+	user, err := ds.ReadUser("nobody@example.com")
+	if err != nil {
+		t.Fatalf("ReadUser: %v", err)
+	}
+	if user != nil {
+		t.Fatalf("ReadUser: got %+v, want nil", user)
+	}
+}
+
+func testCreateAndReadUser(t *testing.T, ds models.Datastore) {
+	err := ds.CreateUser(&models.NewUser{Email: "Alice@Example.com", FirstName: "Alice", LastName: "Smith", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	user := readUser(t, ds, "ALICE@example.COM")
+	if user.ID == 0 {
+		t.Error("ID was not assigned")
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("Email: got %q, want it lower cased", user.Email)
+	}
+	if user.FirstName != "Alice" || user.LastName != "Smith" || user.Password != "secret" {
+		t.Errorf("got %+v", user.NewUser)
+	}
+	if user.Pending == nil || *user.Pending {
+		t.Errorf("Pending: got %v, want false", user.Pending)
+	}
+}
+
+func testCreateUserDuplicate(t *testing.T, ds models.Datastore) {
+	createUser(t, ds, "alice@example.com")
+	if err := ds.CreateUser(&models.NewUser{Email: "ALICE@example.com"}); err == nil {
+		t.Fatal("CreateUser with an existing email succeeded")
+	}
+}
+
+func testPendingUserUpgrade(t *testing.T, ds models.Datastore) {
+	Pending := true
+	if err := ds.CreateUser(&models.NewUser{Email: "bob@example.com", Pending: &Pending}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	pending := readUser(t, ds, "bob@example.com")
+	if pending.Pending == nil || !*pending.Pending {
+		t.Fatalf("Pending: got %v, want true", pending.Pending)
+	}
+
+	err := ds.UpdateUser(&models.NewUser{Email: "Bob@example.com", FirstName: "Bob", LastName: "Jones", Password: "secret"})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	user := readUser(t, ds, "bob@example.com")
+	if user.ID != pending.ID {
+		t.Errorf("ID: got %d, want %d", user.ID, pending.ID)
+	}
+	if user.Pending == nil || *user.Pending {
+		t.Errorf("Pending: got %v, want false", user.Pending)
+	}
+	if user.FirstName != "Bob" || user.LastName != "Jones" || user.Password != "secret" {
+		t.Errorf("got %+v", user.NewUser)
+	}
+}
+
+func testVerifyUser(t *testing.T, ds models.Datastore) {
+	user := createUser(t, ds, "alice@example.com")
+	if user.VerifiedAt != nil {
+		t.Fatalf("VerifiedAt of a new user: got %v, want nil", user.VerifiedAt)
+	}
+	at := time.Now().UTC().Truncate(time.Second)
+	rows, err := ds.VerifyUser(user.ID, at)
+	expectRows(t, "VerifyUser", rows, err, 1)
+	rows, err = ds.VerifyUser(user.ID, at.Add(time.Hour))
+	expectRows(t, "VerifyUser twice", rows, err, 0)
+	if user = readUser(t, ds, "alice@example.com"); user.VerifiedAt == nil || !user.VerifiedAt.Equal(at) {
+		t.Errorf("VerifiedAt: got %v, want %v", user.VerifiedAt, at)
+	}
+
+	// Pending users are verified when they complete their registration.
+	Pending := true
+	if err := ds.CreateUser(&models.NewUser{Email: "bob@example.com", Pending: &Pending}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := ds.UpdateUser(&models.NewUser{Email: "bob@example.com", Password: "secret", VerifiedAt: &at}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if bob := readUser(t, ds, "bob@example.com"); bob.VerifiedAt == nil || !bob.VerifiedAt.Equal(at) {
+		t.Errorf("VerifiedAt after UpdateUser: got %v, want %v", bob.VerifiedAt, at)
+	}
+}
+
+func testUpdatePassword(t *testing.T, ds models.Datastore) {
+	user := createUser(t, ds, "alice@example.com")
+	if err := ds.UpdatePassword(user.ID, "new-secret"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	if got := readUser(t, ds, "alice@example.com").Password; got != "new-secret" {
+		t.Errorf("Password: got %q, want %q", got, "new-secret")
+	}
+}
+
+func testSaveToDo(t *testing.T, ds models.Datastore) {
+	user := createUser(t, ds, "alice@example.com")
+	first := saveToDo(t, ds, user.ID, "first")
+	second := saveToDo(t, ds, user.ID, "second")
+	if first.ID == 0 || second.ID == 0 || first.ID == second.ID {
+		t.Fatalf("got ids %d and %d, want distinct non-zero ids", first.ID, second.ID)
+	}
+
+	todos := getAllTasks(t, ds, user.ID)
+	expectTitles(t, todos, "first", "second")
+	if todos[0].ID != first.ID || todos[0].UserID != user.ID {
+		t.Errorf("got %+v", todos[0])
+	}
+}
+
+func testUpdateToDo(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	td := saveToDo(t, ds, alice.ID, "draft")
+
+	rows, err := ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{Title: "stolen"}, UserID: bob.ID})
+	expectRows(t, "UpdateToDo by another user", rows, err, 0)
+	rows, err = ds.UpdateToDo(&models.Todo{ID: td.ID + 1000, NewTodo: models.NewTodo{Title: "missing"}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo of a missing task", rows, err, 0)
+	rows, err = ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{Title: "final"}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+
+	expectTitles(t, getAllTasks(t, ds, alice.ID), "final")
+}
+
+func testDeleteToDo(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	td := saveToDo(t, ds, alice.ID, "doomed")
+	saveToDo(t, ds, alice.ID, "kept")
+
+	rows, err := ds.DeleteToDo(bob.ID, td.ID)
+	expectRows(t, "DeleteToDo by another user", rows, err, 0)
+	rows, err = ds.DeleteToDo(alice.ID, td.ID)
+	expectRows(t, "DeleteToDo", rows, err, 1)
+	rows, err = ds.DeleteToDo(alice.ID, td.ID)
+	expectRows(t, "DeleteToDo of a deleted task", rows, err, 0)
+	rows, err = ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{Title: "revived"}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo of a deleted task", rows, err, 0)
+
+	expectTitles(t, getAllTasks(t, ds, alice.ID), "kept")
+}
+
+func testGetAllTasksIsolation(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	saveToDo(t, ds, alice.ID, "alice 1")
+	saveToDo(t, ds, bob.ID, "bob 1")
+	saveToDo(t, ds, alice.ID, "alice 2")
+
+	expectTitles(t, getAllTasks(t, ds, alice.ID), "alice 1", "alice 2")
+	expectTitles(t, getAllTasks(t, ds, bob.ID), "bob 1")
+	expectTitles(t, getAllTasks(t, ds, bob.ID+alice.ID+1))
+}
+
+func updateStatus(t *testing.T, ds models.Datastore, td *models.Todo, status string) models.Todo {
+	t.Helper()
+	rows, err := ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{Status: status}, UserID: td.UserID})
+	expectRows(t, "UpdateToDo to "+status, rows, err, 1)
+	for _, stored := range getAllTasks(t, ds, td.UserID) {
+		if stored.ID == td.ID {
+			return stored
+		}
+	}
+	t.Fatalf("task %d not found", td.ID)
+	return models.Todo{}
+}
+
+func testTaskStatus(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	td := saveToDo(t, ds, alice.ID, "chore")
+	if td.Status != models.StatusOpen || td.CompletedAt != nil {
+		t.Fatalf("new task: got status %q completed at %v, want open", td.Status, td.CompletedAt)
+	}
+
+	stored := updateStatus(t, ds, td, models.StatusInProgress)
+	if stored.Status != models.StatusInProgress || stored.CompletedAt != nil || stored.Title != "chore" {
+		t.Fatalf("in progress: got %+v", stored)
+	}
+	stored = updateStatus(t, ds, td, models.StatusDone)
+	if stored.Status != models.StatusDone || stored.CompletedAt == nil {
+		t.Fatalf("done: got status %q completed at %v", stored.Status, stored.CompletedAt)
+	}
+	completedAt := *stored.CompletedAt
+	stored = updateStatus(t, ds, td, models.StatusDone)
+	if stored.CompletedAt == nil || !stored.CompletedAt.Equal(completedAt) {
+		t.Fatalf("done again: got completed at %v, want %v", stored.CompletedAt, completedAt)
+	}
+	stored = updateStatus(t, ds, td, models.StatusOpen)
+	if stored.Status != models.StatusOpen || stored.CompletedAt != nil {
+		t.Fatalf("reopened: got status %q completed at %v", stored.Status, stored.CompletedAt)
+	}
+
+	done := &models.Todo{NewTodo: models.NewTodo{Title: "already done", Status: models.StatusDone}, UserID: alice.ID}
+	if err := ds.SaveToDo(done); err != nil {
+		t.Fatalf("SaveToDo: %v", err)
+	}
+	if done.CompletedAt == nil {
+		t.Fatal("task saved as done has no completion time")
+	}
+}
+
+func testGetAllTasksByStatus(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	saveToDo(t, ds, alice.ID, "open")
+	updateStatus(t, ds, saveToDo(t, ds, alice.ID, "done"), models.StatusDone)
+	updateStatus(t, ds, saveToDo(t, ds, alice.ID, "cancelled"), models.StatusCancelled)
+	updateStatus(t, ds, saveToDo(t, ds, bob.ID, "bob done"), models.StatusDone)
+
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Status: models.StatusDone}), "done")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Status: models.StatusOpen}), "open")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Status: models.StatusInProgress}))
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{}), "open", "done", "cancelled")
+}
+
+func saveDueToDo(t *testing.T, ds models.Datastore, userId uint64, title string, dueAt time.Time, reminders ...int64) *models.Todo {
+	t.Helper()
+	td := &models.Todo{NewTodo: models.NewTodo{Title: title, DueAt: &dueAt, ReminderMinutes: reminders}, UserID: userId}
+	if err := ds.SaveToDo(td); err != nil {
+		t.Fatalf("SaveToDo(%s): %v", title, err)
+	}
+	return td
+}
+
+func testGetAllTasksByDueDate(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	now := time.Now().Truncate(time.Second)
+	yesterday, tomorrow := now.Add(-24*time.Hour), now.Add(24*time.Hour)
+	saveDueToDo(t, ds, alice.ID, "late", yesterday)
+	updateStatus(t, ds, saveDueToDo(t, ds, alice.ID, "late but done", yesterday), models.StatusDone)
+	saveDueToDo(t, ds, alice.ID, "soon", tomorrow)
+	saveToDo(t, ds, alice.ID, "whenever")
+
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{DueBefore: &now}), "late", "late but done")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Unfinished: true, DueBefore: &now}), "late")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{DueAfter: &now}), "soon")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{DueAfter: &yesterday, DueBefore: &tomorrow}),
+		"late", "late but done")
+
+	stored := filterTasks(t, ds, alice.ID, models.TaskFilter{DueAfter: &now})[0]
+	if stored.DueAt == nil || !stored.DueAt.Equal(tomorrow) {
+		t.Errorf("DueAt: got %v, want %v", stored.DueAt, tomorrow)
+	}
+}
+
+func dueReminders(t *testing.T, ds models.Datastore, now time.Time) []models.Reminder {
+	t.Helper()
+	reminders, err := ds.DueReminders(now)
+	if err != nil {
+		t.Fatalf("DueReminders: %v", err)
+	}
+	return reminders
+}
+
+func claimReminder(t *testing.T, ds models.Datastore, r models.Reminder) bool {
+	t.Helper()
+	claimed, err := ds.ClaimReminder(r.TaskID, r.RemindAt)
+	if err != nil {
+		t.Fatalf("ClaimReminder: %v", err)
+	}
+	return claimed
+}
+
+func testReminders(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	now := time.Now().Truncate(time.Second)
+	dueAt := now.Add(time.Hour)
+	td := saveDueToDo(t, ds, alice.ID, "report", dueAt, 24*60, 90, 30)
+	updateStatus(t, ds, saveDueToDo(t, ds, alice.ID, "done", dueAt, 90), models.StatusDone)
+	saveDueToDo(t, ds, alice.ID, "later", now.Add(48*time.Hour), 60)
+
+	// The reminders 24 hours and 90 minutes ahead are due and folded into one.
+	reminders := dueReminders(t, ds, now)
+	if len(reminders) != 1 {
+		t.Fatalf("got %d reminders, want 1: %+v", len(reminders), reminders)
+	}
+	r := reminders[0]
+	if r.TaskID != td.ID || r.UserID != alice.ID || r.Email != "alice@example.com" || r.Title != "report" {
+		t.Errorf("got %+v", r)
+	}
+	if !r.RemindAt.Equal(dueAt.Add(-90*time.Minute)) || !r.DueAt.Equal(dueAt) {
+		t.Errorf("got remind at %v due at %v", r.RemindAt, r.DueAt)
+	}
+
+	if !claimReminder(t, ds, r) {
+		t.Fatal("ClaimReminder: not claimed")
+	}
+	if claimReminder(t, ds, r) {
+		t.Fatal("ClaimReminder: claimed twice")
+	}
+	if reminders := dueReminders(t, ds, now); len(reminders) != 0 {
+		t.Fatalf("got %+v after claiming, want none", reminders)
+	}
+
+	// The 30 minute reminder fires later.
+	reminders = dueReminders(t, ds, now.Add(31*time.Minute))
+	if len(reminders) != 1 || !reminders[0].RemindAt.Equal(dueAt.Add(-30*time.Minute)) {
+		t.Fatalf("got %+v, want the 30 minute reminder", reminders)
+	}
+
+	// Moving the due date rearms the reminders.
+	newDueAt := now.Add(2 * time.Hour)
+	rows, err := ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{DueAt: &newDueAt}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	reminders = dueReminders(t, ds, now)
+	if len(reminders) != 1 || !reminders[0].RemindAt.Equal(newDueAt.Add(-24*time.Hour)) {
+		t.Fatalf("got %+v, want the 24 hour reminder", reminders)
+	}
+
+	// Removing the reminders disarms them.
+	rows, err = ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{ReminderMinutes: []int64{}}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	if reminders := dueReminders(t, ds, now.Add(48*time.Hour)); len(reminders) != 1 || reminders[0].Title != "later" {
+		t.Fatalf("got %+v, want only the reminder of later", reminders)
+	}
+}
+
+func testClearDueAt(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	now := time.Now().Truncate(time.Second)
+	dueAt := now.Add(time.Hour)
+	td := saveDueToDo(t, ds, alice.ID, "report", dueAt, 90)
+	if reminders := dueReminders(t, ds, now); len(reminders) != 1 || !claimReminder(t, ds, reminders[0]) {
+		t.Fatalf("got %+v, want a reminder to claim", reminders)
+	}
+
+	rows, err := ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{ClearDueAt: true}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	if found, err := ds.GetToDo(td.ID); err != nil || found == nil || found.DueAt != nil || found.Title != "report" {
+		t.Fatalf("GetToDo after clearing the due date: got %+v, %v", found, err)
+	}
+	if reminders := dueReminders(t, ds, now.Add(48*time.Hour)); len(reminders) != 0 {
+		t.Fatalf("got %+v without a due date, want none", reminders)
+	}
+
+	// The reminder that was sent fires again for the next due date.
+	rows, err = ds.UpdateToDo(&models.Todo{ID: td.ID, NewTodo: models.NewTodo{DueAt: &dueAt}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	if reminders := dueReminders(t, ds, now); len(reminders) != 1 || reminders[0].TaskID != td.ID {
+		t.Fatalf("got %+v, want the reminder of report again", reminders)
+	}
+}
+
+func testGetAllTasksFilters(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	saveToDo(t, ds, alice.ID, "Buy milk")
+	saveToDo(t, ds, alice.ID, "buy 100% cotton shirt")
+	saveToDo(t, ds, alice.ID, "Call mum")
+
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Text: "BUY"}), "Buy milk", "buy 100% cotton shirt")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Text: "0% c"}), "buy 100% cotton shirt")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Text: "_"}))
+
+	page := queryTasks(t, ds, alice.ID, models.TaskQuery{TaskFilter: models.TaskFilter{Text: "buy"}, Limit: 1})
+	if page.Total != 2 || len(page.Tasks) != 1 || page.NextPage == "" {
+		t.Errorf("got total %d, %d tasks, next page %q, want 2, 1 and a token", page.Total, len(page.Tasks), page.NextPage)
+	}
+
+	future := time.Now().Add(time.Hour)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{CreatedAfter: &future}))
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{CreatedBefore: &future}),
+		"Buy milk", "buy 100% cotton shirt", "Call mum")
+}
+
+func testGetAllTasksPages(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	want := []string{}
+	for i := 0; i < 7; i++ {
+		title := fmt.Sprintf("task %d", i)
+		saveToDo(t, ds, alice.ID, title)
+		saveToDo(t, ds, bob.ID, "bob "+title)
+		want = append(want, title)
+	}
+	reversed := []string{}
+	for i := len(want) - 1; i >= 0; i-- {
+		reversed = append(reversed, want[i])
+	}
+
+	first := queryTasks(t, ds, alice.ID, models.TaskQuery{Limit: 3})
+	if first.Total != 7 {
+		t.Errorf("Total: got %d, want 7", first.Total)
+	}
+	expectTitles(t, first.Tasks, want[:3]...)
+
+	for _, sort := range []string{"", models.SortCreated, models.SortUpdated} {
+		for _, limit := range []int{1, 3, 7, 10} {
+			expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: sort, Limit: limit}), want...)
+			expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: sort, Descending: true, Limit: limit}),
+				reversed...)
+		}
+	}
+
+	// A cursor cannot be used with another sort order.
+	cursor, err := models.DecodeTaskCursor(first.NextPage)
+	if err != nil {
+		t.Fatalf("DecodeTaskCursor: %v", err)
+	}
+	if _, err = ds.GetAllTasks(alice.ID, models.TaskQuery{Descending: true, Limit: 3, Cursor: cursor}); err == nil {
+		t.Error("GetAllTasks accepted the cursor of another sort order")
+	}
+}
+
+func testGetAllTasksSortByDue(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	now := time.Now().Truncate(time.Second)
+	saveToDo(t, ds, alice.ID, "no date 1")
+	saveDueToDo(t, ds, alice.ID, "in two days", now.Add(48*time.Hour))
+	saveDueToDo(t, ds, alice.ID, "tomorrow 1", now.Add(24*time.Hour))
+	saveToDo(t, ds, alice.ID, "no date 2")
+	saveDueToDo(t, ds, alice.ID, "tomorrow 2", now.Add(24*time.Hour))
+
+	for _, limit := range []int{0, 1, 2, 4} {
+		expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortDue, Limit: limit}),
+			"tomorrow 1", "tomorrow 2", "in two days", "no date 1", "no date 2")
+		expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortDue, Descending: true, Limit: limit}),
+			"in two days", "tomorrow 2", "tomorrow 1", "no date 2", "no date 1")
+	}
+}
+
+func searchTasks(t *testing.T, ds models.Datastore, userId uint64, text string, limit int) []models.Todo {
+	t.Helper()
+	todos, err := ds.SearchTasks(userId, text, limit)
+	if err != nil {
+		t.Fatalf("SearchTasks(%q): %v", text, err)
+	}
+	return todos
+}
+
+func testSearchTasks(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	for _, td := range []models.Todo{
+		{NewTodo: models.NewTodo{Title: "Plan trip", Description: "Book a hotel in Paris"}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "Paris museum list"}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "Groceries", Description: "milk, bread"}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "Paris"}, UserID: bob.ID},
+	} {
+		td := td
+		if err := ds.SaveToDo(&td); err != nil {
+			t.Fatalf("SaveToDo: %v", err)
+		}
+	}
+	deleted := saveToDo(t, ds, alice.ID, "Paris flight")
+	if _, err := ds.DeleteToDo(alice.ID, deleted.ID); err != nil {
+		t.Fatalf("DeleteToDo: %v", err)
+	}
+
+	// Matches in the title rank above matches in the description.
+	expectTitles(t, searchTasks(t, ds, alice.ID, "par", 0), "Paris museum list", "Plan trip")
+	expectTitles(t, searchTasks(t, ds, alice.ID, "par", 1), "Paris museum list")
+	expectTitles(t, searchTasks(t, ds, alice.ID, "BREAD milk", 0), "Groceries")
+	expectTitles(t, searchTasks(t, ds, alice.ID, "paris hotel", 0), "Plan trip")
+	expectTitles(t, searchTasks(t, ds, alice.ID, "paris zoo", 0))
+	expectTitles(t, searchTasks(t, ds, alice.ID, " ,.% ", 0))
+
+	groceries := searchTasks(t, ds, alice.ID, "groceries", 0)[0]
+	rows, err := ds.UpdateToDo(&models.Todo{ID: groceries.ID, NewTodo: models.NewTodo{Description: "eggs"}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	expectTitles(t, searchTasks(t, ds, alice.ID, "milk", 0))
+	expectTitles(t, searchTasks(t, ds, alice.ID, "eggs", 0), "Groceries")
+	if found := searchTasks(t, ds, alice.ID, "eggs", 0); found[0].Description != "eggs" {
+		t.Errorf("Description: got %q, want eggs", found[0].Description)
+	}
+}
+
+func testPriority(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	for _, td := range []models.Todo{
+		{NewTodo: models.NewTodo{Title: "normal 1"}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "urgent", Priority: models.PriorityUrgent}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "low", Priority: models.PriorityLow}, UserID: alice.ID},
+		{NewTodo: models.NewTodo{Title: "normal 2"}, UserID: alice.ID},
+	} {
+		td := td
+		if err := ds.SaveToDo(&td); err != nil {
+			t.Fatalf("SaveToDo: %v", err)
+		}
+	}
+	for _, limit := range []int{0, 1, 3} {
+		expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortPriority, Descending: true, Limit: limit}),
+			"urgent", "normal 2", "normal 1", "low")
+	}
+
+	low := allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortPriority})[0]
+	rows, err := ds.UpdateToDo(&models.Todo{ID: low.ID, NewTodo: models.NewTodo{Priority: models.PriorityHigh}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	rows, err = ds.UpdateToDo(&models.Todo{ID: low.ID, NewTodo: models.NewTodo{Title: "was low"}, UserID: alice.ID})
+	expectRows(t, "UpdateToDo", rows, err, 1)
+	expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortPriority, Descending: true}),
+		"urgent", "was low", "normal 2", "normal 1")
+}
+
+func testMoveToDo(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	ids := map[string]uint64{}
+	for _, title := range []string{"a", "b", "c", "d"} {
+		ids[title] = saveToDo(t, ds, alice.ID, title).ID
+	}
+	bobs := saveToDo(t, ds, bob.ID, "bob's")
+	byPosition := models.TaskQuery{Sort: models.SortPosition}
+	expectTitles(t, allPages(t, ds, alice.ID, byPosition), "a", "b", "c", "d")
+
+	for _, move := range []struct {
+		task, anchor string
+		after        bool
+		want         []string
+	}{
+		{"d", "a", false, []string{"d", "a", "b", "c"}},
+		{"a", "c", true, []string{"d", "b", "c", "a"}},
+		{"b", "a", true, []string{"d", "c", "a", "b"}},
+		{"c", "a", false, []string{"d", "c", "a", "b"}},
+		{"c", "d", false, []string{"c", "d", "a", "b"}},
+		{"b", "d", false, []string{"c", "b", "d", "a"}},
+		{"a", "b", false, []string{"c", "a", "b", "d"}},
+		{"a", "b", false, []string{"c", "a", "b", "d"}},
+		{"d", "c", true, []string{"c", "d", "a", "b"}},
+	} {
+		rows, err := ds.MoveToDo(alice.ID, ids[move.task], ids[move.anchor], move.after)
+		expectRows(t, "MoveToDo", rows, err, 1)
+		expectTitles(t, allPages(t, ds, alice.ID, byPosition), move.want...)
+	}
+	// New tasks go last.
+	saveToDo(t, ds, alice.ID, "e")
+	expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortPosition, Limit: 2}),
+		"c", "d", "a", "b", "e")
+
+	rows, err := ds.MoveToDo(alice.ID, ids["a"], bobs.ID, false)
+	expectRows(t, "MoveToDo next to another user's task", rows, err, 0)
+	rows, err = ds.MoveToDo(bob.ID, ids["a"], bobs.ID, false)
+	expectRows(t, "MoveToDo of another user's task", rows, err, 0)
+	expectTitles(t, getAllTasks(t, ds, bob.ID), "bob's")
+}
+
+// testMoveToDoTies moves tasks next to tasks of other users that have the same
+// position, which happens once their tasks are shared.
+func testMoveToDoTies(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	alices := saveToDo(t, ds, alice.ID, "alice's")
+	bobs := saveToDo(t, ds, bob.ID, "bob's")
+	list := createList(t, ds, alice.ID, "shared")
+	rows, err := ds.SetToDoList(alice.ID, alices.ID, list.ID)
+	expectRows(t, "SetToDoList", rows, err, 1)
+	if err := ds.ShareList(list.ID, bob.ID, models.RoleEditor); err != nil {
+		t.Fatalf("ShareList: %v", err)
+	}
+	last := saveToDo(t, ds, bob.ID, "last")
+	byPosition := models.TaskQuery{Sort: models.SortPosition}
+	expectTitles(t, allPages(t, ds, bob.ID, byPosition), "alice's", "bob's", "last")
+
+	rows, err = ds.MoveToDo(bob.ID, last.ID, bobs.ID, false)
+	expectRows(t, "MoveToDo before a tied task", rows, err, 1)
+	expectTitles(t, allPages(t, ds, bob.ID, byPosition), "last", "alice's", "bob's")
+	rows, err = ds.MoveToDo(bob.ID, last.ID, alices.ID, true)
+	expectRows(t, "MoveToDo after a tied task", rows, err, 1)
+	expectTitles(t, allPages(t, ds, bob.ID, byPosition), "alice's", "bob's", "last")
+}
+
+func createList(t *testing.T, ds models.Datastore, userId uint64, name string) *models.List {
+	t.Helper()
+	list := &models.List{NewList: models.NewList{Name: name}, UserID: userId}
+	if err := ds.CreateList(list); err != nil {
+		t.Fatalf("CreateList(%s): %v", name, err)
+	}
+	return list
+}
+
+func expectLists(t *testing.T, ds models.Datastore, userId uint64, archived bool, names ...string) {
+	t.Helper()
+	lists, err := ds.GetLists(userId, archived)
+	if err != nil {
+		t.Fatalf("GetLists: %v", err)
+	}
+	got := []string{}
+	for _, list := range lists {
+		got = append(got, list.Name)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(names) {
+		t.Errorf("got lists %q, want %q", got, names)
+	}
+}
+
+func testLists(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	work := createList(t, ds, alice.ID, "Work")
+	home := createList(t, ds, alice.ID, "Home")
+	createList(t, ds, bob.ID, "Bob's")
+	expectLists(t, ds, alice.ID, false, "Work", "Home")
+
+	if list, err := ds.GetList(alice.ID, work.ID); err != nil || list == nil || list.Name != "Work" {
+		t.Errorf("GetList: got %v, %v, want Work", list, err)
+	}
+	if list, err := ds.GetList(bob.ID, work.ID); err != nil || list != nil {
+		t.Errorf("GetList by another user: got %v, %v, want nil", list, err)
+	}
+
+	rows, err := ds.UpdateList(&models.List{ID: work.ID, NewList: models.NewList{Name: "Office"}, UserID: bob.ID})
+	expectRows(t, "UpdateList by another user", rows, err, 0)
+	rows, err = ds.UpdateList(&models.List{ID: work.ID, NewList: models.NewList{Name: "Office"}, UserID: alice.ID})
+	expectRows(t, "UpdateList", rows, err, 1)
+	rows, err = ds.UpdateList(&models.List{ID: work.ID, UserID: alice.ID})
+	expectRows(t, "UpdateList without changes", rows, err, 1)
+	expectLists(t, ds, alice.ID, false, "Office", "Home")
+
+	rows, err = ds.ArchiveList(bob.ID, home.ID, true)
+	expectRows(t, "ArchiveList by another user", rows, err, 0)
+	rows, err = ds.ArchiveList(alice.ID, home.ID, true)
+	expectRows(t, "ArchiveList", rows, err, 1)
+	expectLists(t, ds, alice.ID, false, "Office")
+	expectLists(t, ds, alice.ID, true, "Office", "Home")
+	if list, err := ds.GetList(alice.ID, home.ID); err != nil || list == nil || list.ArchivedAt == nil {
+		t.Errorf("GetList of an archived list: got %v, %v, want an archive time", list, err)
+	}
+	rows, err = ds.ArchiveList(alice.ID, home.ID, false)
+	expectRows(t, "ArchiveList to restore", rows, err, 1)
+	expectLists(t, ds, alice.ID, false, "Office", "Home")
+
+	rows, err = ds.DeleteList(bob.ID, work.ID)
+	expectRows(t, "DeleteList by another user", rows, err, 0)
+	rows, err = ds.DeleteList(alice.ID, work.ID)
+	expectRows(t, "DeleteList", rows, err, 1)
+	rows, err = ds.DeleteList(alice.ID, work.ID)
+	expectRows(t, "DeleteList twice", rows, err, 0)
+	expectLists(t, ds, alice.ID, true, "Home")
+	if list, err := ds.GetList(alice.ID, work.ID); err != nil || list != nil {
+		t.Errorf("GetList of a deleted list: got %v, %v, want nil", list, err)
+	}
+}
+
+func testTaskLists(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	work := createList(t, ds, alice.ID, "Work")
+	home := createList(t, ds, alice.ID, "Home")
+	bobs := createList(t, ds, bob.ID, "Bob's")
+
+	report := &models.Todo{NewTodo: models.NewTodo{Title: "report", ListID: &work.ID}, UserID: alice.ID}
+	if err := ds.SaveToDo(report); err != nil {
+		t.Fatalf("SaveToDo: %v", err)
+	}
+	dishes := saveToDo(t, ds, alice.ID, "dishes")
+	saveToDo(t, ds, alice.ID, "inbox")
+	none := uint64(0)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &work.ID}), "report")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &none}), "dishes", "inbox")
+
+	rows, err := ds.SetToDoList(alice.ID, dishes.ID, home.ID)
+	expectRows(t, "SetToDoList", rows, err, 1)
+	rows, err = ds.SetToDoList(alice.ID, dishes.ID, bobs.ID)
+	expectRows(t, "SetToDoList into another user's list", rows, err, 0)
+	rows, err = ds.SetToDoList(bob.ID, dishes.ID, bobs.ID)
+	expectRows(t, "SetToDoList of another user's task", rows, err, 0)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &home.ID}), "dishes")
+
+	rows, err = ds.SetToDoList(alice.ID, report.ID, home.ID)
+	expectRows(t, "SetToDoList to another list", rows, err, 1)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &home.ID}), "report", "dishes")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &work.ID}))
+
+	rows, err = ds.SetToDoList(alice.ID, report.ID, 0)
+	expectRows(t, "SetToDoList out of a list", rows, err, 1)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &none}), "report", "inbox")
+
+	// The tasks of a deleted list are kept outside of any list.
+	rows, err = ds.DeleteList(alice.ID, home.ID)
+	expectRows(t, "DeleteList", rows, err, 1)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{ListID: &none}), "report", "dishes", "inbox")
+	rows, err = ds.SetToDoList(alice.ID, report.ID, home.ID)
+	expectRows(t, "SetToDoList into a deleted list", rows, err, 0)
+}
+
+func expectRole(t *testing.T, what string, role string, err error, want string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if role != want {
+		t.Errorf("%s: got role %q, want %q", what, role, want)
+	}
+}
+
+func testSharedLists(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	carol := createUser(t, ds, "carol@example.com")
+	shared := createList(t, ds, alice.ID, "Shared")
+	private := createList(t, ds, alice.ID, "Private")
+	task := &models.Todo{NewTodo: models.NewTodo{Title: "shared task", ListID: &shared.ID}, UserID: alice.ID}
+	if err := ds.SaveToDo(task); err != nil {
+		t.Fatalf("SaveToDo: %v", err)
+	}
+	own := saveToDo(t, ds, alice.ID, "alice's task")
+
+	if err := ds.ShareList(shared.ID, bob.ID, models.RoleViewer); err != nil {
+		t.Fatalf("ShareList: %v", err)
+	}
+	role, err := ds.TaskRole(alice.ID, task.ID)
+	expectRole(t, "TaskRole of the list owner", role, err, models.RoleOwner)
+	role, err = ds.TaskRole(bob.ID, task.ID)
+	expectRole(t, "TaskRole of a viewer", role, err, models.RoleViewer)
+	role, err = ds.TaskRole(carol.ID, task.ID)
+	expectRole(t, "TaskRole of a stranger", role, err, "")
+	role, err = ds.TaskRole(bob.ID, own.ID)
+	expectRole(t, "TaskRole of another user's own task", role, err, "")
+	role, err = ds.TaskRole(alice.ID, own.ID+1000)
+	expectRole(t, "TaskRole of a missing task", role, err, "")
+
+	// Viewers read the tasks of the list but cannot change them.
+	expectTitles(t, getAllTasks(t, ds, bob.ID), "shared task")
+	expectTitles(t, searchTasks(t, ds, bob.ID, "task", 0), "shared task")
+	expectLists(t, ds, bob.ID, false, "Shared")
+	if list, err := ds.GetList(bob.ID, shared.ID); err != nil || list == nil || list.Role != models.RoleViewer {
+		t.Errorf("GetList of a viewer: got %v, %v, want the viewer role", list, err)
+	}
+	if list, err := ds.GetList(bob.ID, private.ID); err != nil || list != nil {
+		t.Errorf("GetList of a list not shared: got %v, %v, want nil", list, err)
+	}
+	rows, err := ds.UpdateToDo(&models.Todo{ID: task.ID, NewTodo: models.NewTodo{Title: "by bob"}, UserID: bob.ID})
+	expectRows(t, "UpdateToDo by a viewer", rows, err, 0)
+	rows, err = ds.DeleteToDo(bob.ID, task.ID)
+	expectRows(t, "DeleteToDo by a viewer", rows, err, 0)
+	rows, err = ds.SetToDoList(bob.ID, task.ID, 0)
+	expectRows(t, "SetToDoList by a viewer", rows, err, 0)
+
+	// Editors change the tasks, but not the list.
+	if err := ds.ShareList(shared.ID, bob.ID, models.RoleEditor); err != nil {
+		t.Fatalf("ShareList: %v", err)
+	}
+	rows, err = ds.UpdateToDo(&models.Todo{ID: task.ID, NewTodo: models.NewTodo{Title: "by bob"}, UserID: bob.ID})
+	expectRows(t, "UpdateToDo by an editor", rows, err, 1)
+	rows, err = ds.UpdateList(&models.List{ID: shared.ID, NewList: models.NewList{Name: "Bob's"}, UserID: bob.ID})
+	expectRows(t, "UpdateList by an editor", rows, err, 0)
+	rows, err = ds.ArchiveList(bob.ID, shared.ID, true)
+	expectRows(t, "ArchiveList by an editor", rows, err, 0)
+	rows, err = ds.DeleteList(bob.ID, shared.ID)
+	expectRows(t, "DeleteList by an editor", rows, err, 0)
+	rows, err = ds.SetToDoList(bob.ID, own.ID, shared.ID)
+	expectRows(t, "SetToDoList of another user's own task", rows, err, 0)
+
+	bobs := &models.Todo{NewTodo: models.NewTodo{Title: "bob's task", ListID: &shared.ID}, UserID: bob.ID}
+	if err := ds.SaveToDo(bobs); err != nil {
+		t.Fatalf("SaveToDo: %v", err)
+	}
+	expectTitles(t, getAllTasks(t, ds, alice.ID), "by bob", "alice's task", "bob's task")
+	rows, err = ds.MoveToDo(bob.ID, bobs.ID, task.ID, false)
+	expectRows(t, "MoveToDo by an editor", rows, err, 1)
+	expectTitles(t, allPages(t, ds, alice.ID, models.TaskQuery{Sort: models.SortPosition, TaskFilter: models.TaskFilter{ListID: &shared.ID}}),
+		"bob's task", "by bob")
+
+	// Owners manage the list.
+	if err := ds.ShareList(shared.ID, carol.ID, models.RoleOwner); err != nil {
+		t.Fatalf("ShareList: %v", err)
+	}
+	rows, err = ds.UpdateList(&models.List{ID: shared.ID, NewList: models.NewList{Name: "Team"}, UserID: carol.ID})
+	expectRows(t, "UpdateList by an owner", rows, err, 1)
+	members, err := ds.GetListMembers(shared.ID)
+	if err != nil {
+		t.Fatalf("GetListMembers: %v", err)
+	}
+	if got := fmt.Sprint(members); got != fmt.Sprint([]models.ListMember{
+		{UserID: alice.ID, Email: "alice@example.com", Role: models.RoleOwner},
+		{UserID: bob.ID, Email: "bob@example.com", Role: models.RoleEditor},
+		{UserID: carol.ID, Email: "carol@example.com", Role: models.RoleOwner},
+	}) {
+		t.Errorf("GetListMembers: got %v", members)
+	}
+
+	// A task moved out of a shared list becomes the own task of its creator,
+	// whoever moves it.
+	rows, err = ds.SetToDoList(bob.ID, bobs.ID, 0)
+	expectRows(t, "SetToDoList out of a shared list", rows, err, 1)
+	role, err = ds.TaskRole(bob.ID, bobs.ID)
+	expectRole(t, "TaskRole of a task moved out", role, err, models.RoleOwner)
+	role, err = ds.TaskRole(alice.ID, bobs.ID)
+	expectRole(t, "TaskRole of a task moved out by another user", role, err, "")
+	rows, err = ds.SetToDoList(bob.ID, task.ID, 0)
+	expectRows(t, "SetToDoList of another user's task out of a shared list", rows, err, 1)
+	role, err = ds.TaskRole(alice.ID, task.ID)
+	expectRole(t, "TaskRole of the creator of a task moved out", role, err, models.RoleOwner)
+	role, err = ds.TaskRole(bob.ID, task.ID)
+	expectRole(t, "TaskRole of the mover of another user's task", role, err, "")
+
+	rows, err = ds.UnshareList(shared.ID, bob.ID)
+	expectRows(t, "UnshareList", rows, err, 1)
+	rows, err = ds.UnshareList(shared.ID, bob.ID)
+	expectRows(t, "UnshareList twice", rows, err, 0)
+	expectTitles(t, getAllTasks(t, ds, bob.ID), "bob's task")
+	role, err = ds.TaskRole(bob.ID, task.ID)
+	expectRole(t, "TaskRole of a former member", role, err, "")
+}
+
+func testAssignments(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	carol := createUser(t, ds, "carol@example.com")
+	assign := func(title string, assignee uint64) *models.Todo {
+		td := &models.Todo{NewTodo: models.NewTodo{Title: title}, UserID: assignee,
+			AssignerID: &alice.ID, Assignment: models.AssignmentPending}
+		if err := ds.SaveToDo(td); err != nil {
+			t.Fatalf("SaveToDo: %v", err)
+		}
+		return td
+	}
+	report := assign("report", bob.ID)
+	slides := assign("slides", bob.ID)
+	assign("budget", carol.ID)
+	saveToDo(t, ds, alice.ID, "alice's task")
+
+	delegated := models.TaskFilter{Delegated: true}
+	expectTitles(t, filterTasks(t, ds, alice.ID, delegated), "report", "slides", "budget")
+	expectTitles(t, filterTasks(t, ds, bob.ID, delegated))
+	expectTitles(t, getAllTasks(t, ds, bob.ID), "report", "slides")
+	if td := getAllTasks(t, ds, bob.ID)[0]; td.AssignerID == nil || *td.AssignerID != alice.ID ||
+		td.Assignment != models.AssignmentPending {
+		t.Errorf("got assigner %v and assignment %q, want %d and pending", td.AssignerID, td.Assignment, alice.ID)
+	}
+
+	rows, err := ds.AnswerAssignment(alice.ID, report.ID, models.AssignmentAccepted)
+	expectRows(t, "AnswerAssignment by the assigner", rows, err, 0)
+	rows, err = ds.AnswerAssignment(bob.ID, report.ID, models.AssignmentAccepted)
+	expectRows(t, "AnswerAssignment", rows, err, 1)
+	rows, err = ds.AnswerAssignment(bob.ID, report.ID, models.AssignmentDeclined)
+	expectRows(t, "AnswerAssignment twice", rows, err, 0)
+	rows, err = ds.AnswerAssignment(bob.ID, slides.ID, models.AssignmentDeclined)
+	expectRows(t, "AnswerAssignment to decline", rows, err, 1)
+
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Delegated: true, Assignment: models.AssignmentAccepted}),
+		"report")
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Delegated: true, Assignment: models.AssignmentPending}),
+		"budget")
+	updateStatus(t, ds, report, models.StatusDone)
+	expectTitles(t, filterTasks(t, ds, alice.ID, models.TaskFilter{Delegated: true, Status: models.StatusDone}), "report")
+}
+
+func testHasInvited(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	carol := createUser(t, ds, "carol@example.com")
+	expect := func(inviter, user *models.User, want bool) {
+		t.Helper()
+		invited, err := ds.HasInvited(inviter.ID, user.ID)
+		if err != nil {
+			t.Fatalf("HasInvited: %v", err)
+		}
+		if invited != want {
+			t.Errorf("HasInvited(%s, %s) = %v, want %v", inviter.Email, user.Email, invited, want)
+		}
+	}
+
+	td := &models.Todo{NewTodo: models.NewTodo{Title: "report"}, UserID: bob.ID,
+		AssignerID: &alice.ID, Assignment: models.AssignmentPending}
+	if err := ds.SaveToDo(td); err != nil {
+		t.Fatalf("SaveToDo: %v", err)
+	}
+	list := createList(t, ds, carol.ID, "groceries")
+	if err := ds.ShareList(list.ID, bob.ID, models.RoleViewer); err != nil {
+		t.Fatalf("ShareList: %v", err)
+	}
+	expect(alice, bob, true)
+	expect(carol, bob, true)
+	expect(bob, alice, false)
+	expect(alice, carol, false)
+
+	rows, err := ds.DeleteToDo(bob.ID, td.ID)
+	expectRows(t, "DeleteToDo", rows, err, 1)
+	rows, err = ds.DeleteList(carol.ID, list.ID)
+	expectRows(t, "DeleteList", rows, err, 1)
+	expect(alice, bob, false)
+	expect(carol, bob, false)
+}
+
+func expectUsers(t *testing.T, ds models.Datastore, filter models.UserFilter, emails ...string) {
+	t.Helper()
+	users, err := ds.GetUsers(filter)
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	got := []string{}
+	for _, u := range users {
+		got = append(got, u.Email)
+	}
+	if strings.Join(got, ",") != strings.Join(emails, ",") {
+		t.Errorf("GetUsers(%+v): got %v, want %v", filter, got, emails)
+	}
+}
+
+func testAdminUsers(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	createUser(t, ds, "carol@example.org")
+	if alice.Role != models.UserRoleUser || alice.SuspendedAt != nil {
+		t.Errorf("new user: got role %q and suspension %v, want user and none", alice.Role, alice.SuspendedAt)
+	}
+
+	rows, err := ds.SetUserRole(alice.ID, models.UserRoleAdmin)
+	expectRows(t, "SetUserRole", rows, err, 1)
+	if role := readUser(t, ds, alice.Email).Role; role != models.UserRoleAdmin {
+		t.Errorf("Role: got %q, want admin", role)
+	}
+	expectUsers(t, ds, models.UserFilter{}, "alice@example.com", "bob@example.com", "carol@example.org")
+	expectUsers(t, ds, models.UserFilter{Text: "EXAMPLE.COM"}, "alice@example.com", "bob@example.com")
+	expectUsers(t, ds, models.UserFilter{Role: models.UserRoleAdmin}, "alice@example.com")
+	expectUsers(t, ds, models.UserFilter{AfterID: alice.ID, Limit: 1}, "bob@example.com")
+
+	at := time.Now().UTC().Truncate(time.Second)
+	rows, err = ds.SuspendUser(bob.ID, &at)
+	expectRows(t, "SuspendUser", rows, err, 1)
+	if suspended := readUser(t, ds, bob.Email).SuspendedAt; suspended == nil || !suspended.Equal(at) {
+		t.Errorf("SuspendedAt: got %v, want %v", suspended, at)
+	}
+	rows, err = ds.SuspendUser(bob.ID, nil)
+	expectRows(t, "SuspendUser to lift the suspension", rows, err, 1)
+	if suspended := readUser(t, ds, bob.Email).SuspendedAt; suspended != nil {
+		t.Errorf("SuspendedAt after lifting: got %v, want nil", suspended)
+	}
+
+	rows, err = ds.DeleteUser(bob.ID)
+	expectRows(t, "DeleteUser", rows, err, 1)
+	rows, err = ds.DeleteUser(bob.ID)
+	expectRows(t, "DeleteUser twice", rows, err, 0)
+	if user, err := ds.ReadUser(bob.Email); err != nil || user != nil {
+		t.Errorf("ReadUser of a deleted user: got %v, %v, want nil", user, err)
+	}
+	expectUsers(t, ds, models.UserFilter{Text: "example.com"}, "alice@example.com")
+	rows, err = ds.SetUserRole(bob.ID, models.UserRoleSupport)
+	expectRows(t, "SetUserRole of a deleted user", rows, err, 0)
+}
+
+func testGetToDo(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	td := saveToDo(t, ds, alice.ID, "report")
+	found, err := ds.GetToDo(td.ID)
+	if err != nil {
+		t.Fatalf("GetToDo: %v", err)
+	}
+	if found == nil || found.Title != "report" || found.UserID != alice.ID {
+		t.Errorf("GetToDo: got %+v", found)
+	}
+	rows, err := ds.DeleteToDo(alice.ID, td.ID)
+	expectRows(t, "DeleteToDo", rows, err, 1)
+	if found, err = ds.GetToDo(td.ID); err != nil || found != nil {
+		t.Errorf("GetToDo of a deleted task: got %v, %v, want nil", found, err)
+	}
+}
+
+func testTwoFactor(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	if user, err := ds.ReadUserByID(alice.ID); err != nil || user == nil || user.Email != alice.Email {
+		t.Fatalf("ReadUserByID: got %v, %v", user, err)
+	}
+	if user, err := ds.ReadUserByID(alice.ID + 1); err != nil || user != nil {
+		t.Errorf("ReadUserByID of an unknown user: got %v, %v, want nil", user, err)
+	}
+
+	rows, err := ds.EnableTOTP(alice.ID, time.Now(), []string{"a"})
+	expectRows(t, "EnableTOTP without a secret", rows, err, 0)
+	rows, err = ds.SetTOTPSecret(alice.ID, "SECRET")
+	expectRows(t, "SetTOTPSecret", rows, err, 1)
+	if user := readUser(t, ds, alice.Email); user.TOTPSecret != "SECRET" || user.TOTPEnabledAt != nil {
+		t.Errorf("after SetTOTPSecret: got %q and %v", user.TOTPSecret, user.TOTPEnabledAt)
+	}
+	at := time.Now().UTC().Truncate(time.Second)
+	rows, err = ds.EnableTOTP(alice.ID, at, []string{"code1", "code2"})
+	expectRows(t, "EnableTOTP", rows, err, 1)
+	if user := readUser(t, ds, alice.Email); user.TOTPEnabledAt == nil || !user.TOTPEnabledAt.Equal(at) {
+		t.Errorf("TOTPEnabledAt: got %v, want %v", user.TOTPEnabledAt, at)
+	}
+	rows, err = ds.SetTOTPSecret(alice.ID, "OTHER")
+	expectRows(t, "SetTOTPSecret once enabled", rows, err, 0)
+
+	for _, test := range []struct {
+		step int64
+		want bool
+	}{{100, true}, {100, false}, {99, false}, {101, true}} {
+		if used, err := ds.UseTOTPStep(alice.ID, test.step); err != nil || used != test.want {
+			t.Errorf("UseTOTPStep(%d): got %v, %v, want %v", test.step, used, err, test.want)
+		}
+	}
+	for _, test := range []struct {
+		code string
+		want bool
+	}{{"code1", true}, {"code1", false}, {"unknown", false}, {"code2", true}} {
+		if used, err := ds.UseRecoveryCode(alice.ID, test.code); err != nil || used != test.want {
+			t.Errorf("UseRecoveryCode(%s): got %v, %v, want %v", test.code, used, err, test.want)
+		}
+	}
+
+	rows, err = ds.DisableTOTP(alice.ID)
+	expectRows(t, "DisableTOTP", rows, err, 1)
+	if user := readUser(t, ds, alice.Email); user.TOTPSecret != "" || user.TOTPEnabledAt != nil {
+		t.Errorf("after DisableTOTP: got %q and %v", user.TOTPSecret, user.TOTPEnabledAt)
+	}
+	if used, _ := ds.UseRecoveryCode(alice.ID, "code2"); used {
+		t.Errorf("UseRecoveryCode after DisableTOTP succeeded")
+	}
+	if used, _ := ds.UseTOTPStep(alice.ID, 50); !used {
+		t.Errorf("UseTOTPStep after DisableTOTP failed")
+	}
+}
+
+func createAPIKey(t *testing.T, ds models.Datastore, userId uint64, name string, expiresAt *time.Time) *models.APIKey {
+	t.Helper()
+	key := &models.APIKey{UserID: userId, Name: name, Prefix: name[:3], Hash: "hash-" + name,
+		Scopes: []string{"tasks:read", "account"}, ExpiresAt: expiresAt}
+	if err := ds.CreateAPIKey(key); err != nil {
+		t.Fatalf("CreateAPIKey(%s): %v", name, err)
+	}
+	return key
+}
+
+func testAPIKeys(t *testing.T, ds models.Datastore) {
+	alice := createUser(t, ds, "alice@example.com")
+	bob := createUser(t, ds, "bob@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+	past := now.Add(-time.Hour)
+	ci := createAPIKey(t, ds, alice.ID, "ci-runner", nil)
+	createAPIKey(t, ds, alice.ID, "old-script", &past)
+	backup := createAPIKey(t, ds, bob.ID, "backup", nil)
+	if ci.ID == 0 || ci.CreatedAt.IsZero() {
+		t.Errorf("CreateAPIKey: got %+v", ci)
+	}
+	if err := ds.CreateAPIKey(&models.APIKey{UserID: bob.ID, Name: "copy", Hash: ci.Hash}); err == nil {
+		t.Errorf("CreateAPIKey with a duplicate hash succeeded")
+	}
+
+	keys, err := ds.GetAPIKeys(alice.ID)
+	if err != nil {
+		t.Fatalf("GetAPIKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Name != "ci-runner" || keys[1].Name != "old-script" {
+		t.Fatalf("GetAPIKeys: got %+v, want ci-runner and old-script", keys)
+	}
+
+	rows, err := ds.SetUserRole(alice.ID, models.UserRoleSupport)
+	expectRows(t, "SetUserRole", rows, err, 1)
+	found, err := ds.FindAPIKey(ci.Hash, now)
+	if err != nil || found == nil || found.ID != ci.ID || found.UserID != alice.ID || found.Role != models.UserRoleSupport {
+		t.Fatalf("FindAPIKey: got %+v, %v", found, err)
+	}
+	if len(found.Scopes) != 2 || found.Scopes[0] != "tasks:read" || found.Scopes[1] != "account" {
+		t.Errorf("Scopes: got %q, want tasks:read and account", found.Scopes)
+	}
+	if found, err = ds.FindAPIKey("hash-old-script", now); err != nil || found != nil {
+		t.Errorf("FindAPIKey of an expired key: got %+v, %v, want nil", found, err)
+	}
+	if found, err = ds.FindAPIKey("unknown", now); err != nil || found != nil {
+		t.Errorf("FindAPIKey of an unknown key: got %+v, %v, want nil", found, err)
+	}
+
+	if err = ds.TouchAPIKey(ci.ID, now); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	if keys, _ = ds.GetAPIKeys(alice.ID); keys[0].LastUsedAt == nil || !keys[0].LastUsedAt.Equal(now) {
+		t.Errorf("LastUsedAt: got %v, want %v", keys[0].LastUsedAt, now)
+	}
+
+	rows, err = ds.SuspendUser(bob.ID, &now)
+	expectRows(t, "SuspendUser", rows, err, 1)
+	if found, _ = ds.FindAPIKey(backup.Hash, now); found != nil {
+		t.Errorf("FindAPIKey of a suspended user: got %+v, want nil", found)
+	}
+
+	rows, err = ds.RevokeAPIKey(bob.ID, ci.ID)
+	expectRows(t, "RevokeAPIKey of another user", rows, err, 0)
+	rows, err = ds.RevokeAPIKey(alice.ID, ci.ID)
+	expectRows(t, "RevokeAPIKey", rows, err, 1)
+	rows, err = ds.RevokeAPIKey(alice.ID, ci.ID)
+	expectRows(t, "RevokeAPIKey twice", rows, err, 0)
+	if found, _ = ds.FindAPIKey(ci.Hash, now); found != nil {
+		t.Errorf("FindAPIKey of a revoked key: got %+v, want nil", found)
+	}
+	if keys, _ = ds.GetAPIKeys(alice.ID); len(keys) != 1 || keys[0].Name != "old-script" {
+		t.Errorf("GetAPIKeys after RevokeAPIKey: got %+v, want old-script", keys)
+	}
+}
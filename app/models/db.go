@@ -2,11 +2,13 @@ package models
 
 import (
 	"database/sql"
-	"fmt"
+	"github.com/lib/pq"
 	"github.com/tintash-training/todo-api/app/config"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Datastore interface {
@@ -14,14 +16,100 @@ type Datastore interface {
 	//AddTodo(string, string) (*Todo, error)
 	//GetTodo(int) (*Todo, error)
 
+	// The methods on tasks take the id of the user acting on them, which is
+	// td.UserID for UpdateToDo, and only affect the tasks that the user may
+	// access with the role needed.  Reading takes viewers, changing editors.
 	SaveToDo(td *Todo) error
 	UpdateToDo(td *Todo) (int64, error)
 	DeleteToDo(user uint64, taskId uint64) (int64, error)
-	GetAllTasks(userId uint64) ([]Todo, error)
+	// TaskRole returns the role of the user on a task, or "" if the user may
+	// not access it or it is not found.
+	TaskRole(userId uint64, taskId uint64) (string, error)
+	// AnswerAssignment accepts or declines a pending assignment of a task to
+	// the user.  It returns 0 if there is none.
+	AnswerAssignment(userId uint64, taskId uint64, assignment string) (int64, error)
+	// MoveToDo moves a task right before or, if after is true, right after
+	// the anchor task in the manual order.  It returns 0 if either task is not
+	// found.
+	MoveToDo(userId uint64, taskId uint64, anchorId uint64, after bool) (int64, error)
+	GetAllTasks(userId uint64, query TaskQuery) (*TaskPage, error)
+	// SearchTasks returns up to limit tasks whose title or description contains
+	// every word of text, best matches first.  Zero means no limit.
+	SearchTasks(userId uint64, text string, limit int) ([]Todo, error)
+	// SetToDoList moves a task into a list, or out of any list if listId is 0.
+	// A task moved out of its list stays with the user who created it.  It
+	// returns 0 if the task or the list is not found.
+	SetToDoList(userId uint64, taskId uint64, listId uint64) (int64, error)
+	// The methods on lists up to DeleteList take the id of the user acting on
+	// them, which is list.UserID for UpdateList.  Reading takes any role, the
+	// other methods owners.  ShareList, UnshareList and GetListMembers check
+	// nothing; their callers check the role of the acting user first.
+	CreateList(list *List) error
+	// GetList returns nil if the list is not found.
+	GetList(userId uint64, listId uint64) (*List, error)
+	GetLists(userId uint64, archived bool) ([]List, error)
+	UpdateList(list *List) (int64, error)
+	// ArchiveList archives or, if archived is false, restores a list.
+	ArchiveList(userId uint64, listId uint64, archived bool) (int64, error)
+	// DeleteList deletes a list.  Its tasks are kept and belong to no list.
+	DeleteList(userId uint64, listId uint64) (int64, error)
+	// ShareList adds a member to a list or changes the role of a member.
+	ShareList(listId uint64, memberId uint64, role string) error
+	UnshareList(listId uint64, memberId uint64) (int64, error)
+	// GetListMembers returns the owner and the members of a list.
+	GetListMembers(listId uint64) ([]ListMember, error)
+	// HasInvited reports whether the inviter has assigned a task to the user or
+	// shared a list with them, which is how pending users are invited.
+	HasInvited(inviterId uint64, userId uint64) (bool, error)
+	// DueReminders returns the reminders due at now that have not been sent yet.
+	DueReminders(now time.Time) ([]Reminder, error)
+	// ClaimReminder marks a reminder as sent.  It returns false if it already was.
+	ClaimReminder(taskId uint64, remindAt time.Time) (bool, error)
 	ReadUser(email string) (user *User, err error)
-	CreateTables() error
+	// ReadUserByID returns nil if the user is not found.
+	ReadUserByID(userId uint64) (*User, error)
 	CreateUser(user *NewUser) error
 	UpdateUser(user *NewUser) error
+	UpdatePassword(userId uint64, password string) error
+	// VerifyUser records that the user owns their email address.  It returns 0
+	// if the user is not found or was verified already.
+	VerifyUser(userId uint64, at time.Time) (int64, error)
+	// SetTOTPSecret starts the enrolment of the user in two-factor
+	// authentication.  It returns 0 if the user has completed it already.
+	SetTOTPSecret(userId uint64, secret string) (int64, error)
+	// EnableTOTP completes the enrolment and replaces the recovery codes of the
+	// user, which are given by their hashes.  It returns 0 if there is no
+	// enrolment to complete.
+	EnableTOTP(userId uint64, at time.Time, recoveryCodes []string) (int64, error)
+	DisableTOTP(userId uint64) (int64, error)
+	// UseTOTPStep records the time step of a code of the user.  It returns
+	// false if that step, or a later one, was used already.
+	UseTOTPStep(userId uint64, step int64) (bool, error)
+	// UseRecoveryCode uses up a recovery code given by its hash.  It returns
+	// false if the user has no such unused code.
+	UseRecoveryCode(userId uint64, codeHash string) (bool, error)
+	CreateAPIKey(key *APIKey) error
+	// GetAPIKeys returns the keys of the user that have not been revoked,
+	// including expired ones.
+	GetAPIKeys(userId uint64) ([]APIKey, error)
+	// FindAPIKey returns the key with the hash if it works at now, with the
+	// role of its user.  It returns nil if the key is revoked or expired, or
+	// if its user is deleted or suspended.
+	FindAPIKey(hash string, now time.Time) (*APIKey, error)
+	// TouchAPIKey records when a key was last used.
+	TouchAPIKey(keyId uint64, at time.Time) error
+	RevokeAPIKey(userId uint64, keyId uint64) (int64, error)
+	// The methods of the admin API act on any user and task.
+	GetUsers(filter UserFilter) ([]User, error)
+	SetUserRole(userId uint64, role string) (int64, error)
+	// SuspendUser suspends a user at the time given, or lifts the suspension
+	// if at is nil.
+	SuspendUser(userId uint64, at *time.Time) (int64, error)
+	DeleteUser(userId uint64) (int64, error)
+	// GetToDo returns nil if the task is not found.
+	GetToDo(taskId uint64) (*Todo, error)
+	Ping() error
+	Close() error
 }
 
 type GormDB struct {
@@ -32,28 +120,29 @@ type SqlDB struct {
 	*sql.DB
 }
 
-func (db *GormDB) CreateTables() error {
-	users := []User{
-		{NewUser: NewUser{Email: "bob.smith@gmail.com", FirstName: "Bob", LastName: "Smith", Password: "password"}},
-		{NewUser: NewUser{Email: "john.doe@gmail.com", FirstName: "John", LastName: "Doe", Password: "password"}}}
-	result := db.Create(&users) // pass pointer of data to Create
-
-	return result.Error
+func (db *GormDB) Ping() error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
 }
 
-// ReadUser database/sql implementation
-func (db *SqlDB) ReadUser(email string) (user *User, err error) {
-	user = &User{}
-	query := fmt.Sprintf("SELECT * FROM users WHERE email = '%s'", strings.ToLower(email))
-	rows, err := db.Query(query)
+func (db *GormDB) Close() error {
+	sqlDB, err := db.DB.DB()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return sqlDB.Close()
+}
 
-	if rows.Next() {
-		err = rows.Scan(&user.ID, &user.Email, &user.Password)
-	} else {
-		user = nil
+// ReadUser database/sql implementation
+func (db *SqlDB) ReadUser(email string) (user *User, err error) {
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1 AND deleted_at IS NULL LIMIT 1;",
+		strings.ToLower(email))
+	user, err = scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
 	return
 }
@@ -69,23 +158,25 @@ func (db *GormDB) ReadUser(email string) (user *User, err error) {
 	return
 }
 
-func makeDataSourceName(config *config.DBConfig) string {
-	return fmt.Sprintf("host=%s user=%s dbname=%s sslmode=%s port=%d password=%s",
-		config.Host,
-		config.Username,
-		config.Name,
-		config.SSLMode,
-		config.Port,
-		config.Password)
+func configurePool(db *sql.DB, config *config.DBConfig) error {
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	return db.Ping()
 }
 
 func connectGormDB(config *config.DBConfig) (ds Datastore, err error) {
 	var db *gorm.DB
-	db, err = gorm.Open(postgres.Open(makeDataSourceName(config)), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(config.DataSourceName()), &gorm.Config{})
+	if err != nil {
+		return
+	}
+	sqlDB, err := db.DB()
 	if err != nil {
 		return
 	}
-	err = db.AutoMigrate(&User{}, &Todo{})
+	err = configurePool(sqlDB, config)
 	if err != nil {
 		return
 	}
@@ -96,114 +187,422 @@ func connectGormDB(config *config.DBConfig) (ds Datastore, err error) {
 
 func connectSqlDB(config *config.DBConfig) (ds Datastore, err error) {
 	var db *sql.DB
-	db, err = sql.Open("postgres", makeDataSourceName(config))
+	db, err = sql.Open("postgres", config.DataSourceName())
+	if err != nil {
+		return
+	}
+	err = configurePool(db, config)
+	if err != nil {
+		return
+	}
+
 	ds = Datastore(&SqlDB{db})
 	return
 }
 
+// ConnectDS opens the Datastore selected by config.Impl.  The Datastore holds a
+// connection pool and is meant to be shared for the lifetime of the process.
 func ConnectDS(config *config.DBConfig) (ds Datastore, err error) {
 	switch config.Impl {
 	case "gorm":
 		return connectGormDB(config)
 	case "sql":
 		return connectSqlDB(config)
+	case "memory":
+		return NewMemoryDB(), nil
 	default:
 		panic(config.Impl)
 	}
 }
 
-func (db *SqlDB) CreateTables() error {
-	query := `
-		DROP TABLE IF EXISTS todos;
-		DROP TABLE IF EXISTS users;
-		CREATE TABLE users (
-		    id SERIAL PRIMARY KEY,
-   			email varchar,
-   			password varchar
-		); `
-	_, err := db.Query(query)
-	if err != nil {
-		return err
-	}
+const userColumns = "id, created_at, updated_at, deleted_at, email, first_name, last_name, password, pending, verified_at, role, suspended_at, " +
+	"totp_secret, totp_enabled_at"
 
-	query = `
-		INSERT INTO users (email, password) VALUES ( 'paul.smith@gmail.com', 'password');
-		INSERT INTO users (email, password) VALUES ( 'john.doe@gmail.com', 'password'); `
+const todoColumns = "id, created_at, updated_at, deleted_at, title, description, userid, status, completed_at, " +
+	"due_at, time_zone, reminder_minutes, reminded_at, priority, position, list_id, assigner_id, assignment"
 
-	_, err = db.Query(query)
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*User, error) {
+	user := &User{}
+	err := row.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt,
+		&user.Email, &user.FirstName, &user.LastName, &user.Password, &user.Pending, &user.VerifiedAt,
+		&user.Role, &user.SuspendedAt, &user.TOTPSecret, &user.TOTPEnabledAt)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return user, nil
+}
 
-	query = `
-		CREATE TABLE todos (
-   		userid INT,
-   		title varchar,
-   		CONSTRAINT fk_user
-      		FOREIGN KEY(userid) 
-	  		REFERENCES users(id)
-		); `
-
-	_, err = db.Query(query)
+func scanTodo(row scanner) (*Todo, error) {
+	td := &Todo{}
+	err := row.Scan(&td.ID, &td.CreatedAt, &td.UpdatedAt, &td.DeletedAt, &td.Title, &td.Description, &td.UserID,
+		&td.Status, &td.CompletedAt, &td.DueAt, &td.TimeZone, &td.ReminderMinutes, &td.RemindedAt,
+		&td.Priority, &td.Position, &td.ListID, &td.AssignerID, &td.Assignment)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return err
+	return td, nil
 }
 
 func (db *GormDB) SaveToDo(td *Todo) error {
-	result := db.Create(td) // pass pointer of data to Create
-	return result.Error
+	td.prepareNew(time.Now())
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(lockPositionsSQL, td.UserID).Error; err != nil {
+			return err
+		}
+		var last string
+		query, args := lastPositionSQL(td.UserID)
+		err := tx.Raw(query, args...).Row().Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		td.Position = rankBetween(last, "")
+		return tx.Create(td).Error // pass pointer of data to Create
+	})
+}
+
+func (db *GormDB) MoveToDo(userId uint64, taskId uint64, anchorId uint64, after bool) (rows int64, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(lockPositionsSQL, userId).Error; err != nil {
+			return err
+		}
+		var anchor, neighbour string
+		query, args := anchorPositionSQL(userId, anchorId)
+		err := tx.Raw(query, args...).Row().Scan(&anchor)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		query, args = neighbourPositionSQL(userId, taskId, anchor, after)
+		err = tx.Raw(query, args...).Row().Scan(&neighbour)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		query, args = movePositionSQL(userId, taskId, movedPosition(anchor, neighbour, after))
+		result := tx.Exec(query, args...)
+		rows = result.RowsAffected
+		return result.Error
+	})
+	return
 }
 
 func (db *GormDB) UpdateToDo(td *Todo) (int64, error) {
-	result := db.Model(&Todo{}).Where("ID = ? and userid = ?", td.ID, td.UserID).Updates(
-		Todo{NewTodo: NewTodo{Title: td.Title}})
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if td.Title != "" {
+		updates["title"] = td.Title
+	}
+	if td.Description != "" {
+		updates["description"] = td.Description
+	}
+	if td.Priority != 0 {
+		updates["priority"] = td.Priority
+	}
+	if td.Status != "" {
+		updates["status"] = td.Status
+		if td.Status == StatusDone {
+			// Keep the original completion time of a task that was already done.
+			updates["completed_at"] = gorm.Expr("COALESCE(completed_at, ?)", time.Now())
+		} else {
+			updates["completed_at"] = nil
+		}
+	}
+	if td.DueAt != nil {
+		updates["due_at"] = td.DueAt
+		updates["reminded_at"] = nil
+	} else if td.ClearDueAt {
+		updates["due_at"] = nil
+		updates["reminded_at"] = nil
+	}
+	if td.TimeZone != "" {
+		updates["time_zone"] = td.TimeZone
+	}
+	if td.ReminderMinutes != nil {
+		updates["reminder_minutes"] = td.ReminderMinutes
+		updates["reminded_at"] = nil
+	}
+	access, args := taskAccessSQL(td.UserID, RoleEditor)
+	result := db.Model(&Todo{}).Where("id = ?", td.ID).Where(access, args...).Updates(updates)
 
 	return result.RowsAffected, result.Error
 }
 
-func (db *GormDB) GetAllTasks(userId uint64) ([]Todo, error) {
+func (db *GormDB) GetAllTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	if err := query.checkCursor(); err != nil {
+		return nil, err
+	}
+	page := &TaskPage{Tasks: []Todo{}}
+	filter, filterArgs := taskFilterSQL(userId, query.TaskFilter)
+	result := db.Model(&Todo{}).Where(filter, filterArgs...).Count(&page.Total)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	where, args, order := taskPageSQL(userId, query)
+	tx := db.Where(where, args...).Order(order)
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit + 1)
+	}
+	result = tx.Find(&page.Tasks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	query.finishPage(page)
+	return page, nil
+}
+
+func (db *SqlDB) GetAllTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	if err := query.checkCursor(); err != nil {
+		return nil, err
+	}
+	page := &TaskPage{Tasks: []Todo{}}
+	filter, filterArgs := taskFilterSQL(userId, query.TaskFilter)
+	err := db.QueryRow(rebind("SELECT count(*) FROM todos WHERE "+filter+";"), filterArgs...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, order := taskPageSQL(userId, query)
+	limit := ""
+	if query.Limit > 0 {
+		limit = " LIMIT " + strconv.Itoa(query.Limit+1)
+	}
+	rows, err := db.Query(rebind("SELECT "+todoColumns+" FROM todos WHERE "+where+" ORDER BY "+order+limit+";"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		td, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Tasks = append(page.Tasks, *td)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	query.finishPage(page)
+	return page, nil
+}
+
+func (db *GormDB) SearchTasks(userId uint64, text string, limit int) ([]Todo, error) {
 	todos := []Todo{}
-	result := db.Where("userid = ?", userId).Find(&todos)
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return todos, nil
+	}
+	query, args := searchTasksSQL(userId, terms, limit)
+	result := db.Raw(query, args...).Scan(&todos)
 	return todos, result.Error
 }
 
-func (db *SqlDB) GetAllTasks(userId uint64) ([]Todo, error) {
-	return nil, fmt.Errorf("not implemented")
+func (db *SqlDB) SearchTasks(userId uint64, text string, limit int) ([]Todo, error) {
+	todos := []Todo{}
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return todos, nil
+	}
+	query, args := searchTasksSQL(userId, terms, limit)
+	rows, err := db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		td, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, *td)
+	}
+	return todos, rows.Err()
+}
+
+func (db *GormDB) DueReminders(now time.Time) ([]Reminder, error) {
+	reminders := []Reminder{}
+	result := db.Raw(dueRemindersSQL, now).Scan(&reminders)
+	return reminders, result.Error
+}
+
+func (db *SqlDB) DueReminders(now time.Time) ([]Reminder, error) {
+	rows, err := db.Query(rebind(dueRemindersSQL), now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		var r Reminder
+		err := rows.Scan(&r.TaskID, &r.UserID, &r.Email, &r.Title, &r.DueAt, &r.TimeZone, &r.RemindAt)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+func (db *GormDB) ClaimReminder(taskId uint64, remindAt time.Time) (bool, error) {
+	result := db.Exec(claimReminderSQL, remindAt, taskId, remindAt)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *SqlDB) ClaimReminder(taskId uint64, remindAt time.Time) (bool, error) {
+	result, err := db.Exec(rebind(claimReminderSQL), remindAt, taskId, remindAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
 }
 
 func (db *GormDB) DeleteToDo(userId uint64, taskId uint64) (int64, error) {
-	result := db.Where("ID = ? and userid = ?", taskId, userId).Delete(&Todo{})
+	access, args := taskAccessSQL(userId, RoleEditor)
+	result := db.Where("id = ?", taskId).Where(access, args...).Delete(&Todo{})
 
 	return result.RowsAffected, result.Error
 }
 
+// DeleteToDo soft deletes the task, like GORM does for models with a DeletedAt field.
 func (db *SqlDB) DeleteToDo(userId uint64, taskId uint64) (int64, error) {
-	return 0, fmt.Errorf("not implemented")
+	access, args := taskAccessSQL(userId, RoleEditor)
+	result, err := db.Exec(rebind("UPDATE todos SET deleted_at = now() WHERE id = ? AND deleted_at IS NULL AND "+access+";"),
+		append([]interface{}{taskId}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (db *SqlDB) SaveToDo(td *Todo) error {
-	_, err := db.Query("INSERT INTO todos (userid, title) VALUES ($1, $2);", td.UserID, td.Title)
-	return err
+	td.prepareNew(time.Now())
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebind(lockPositionsSQL), td.UserID); err != nil {
+		return err
+	}
+	var last string
+	query, args := lastPositionSQL(td.UserID)
+	err = tx.QueryRow(rebind(query), args...).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	td.Position = rankBetween(last, "")
+	row := tx.QueryRow(`INSERT INTO todos (created_at, updated_at, title, description, userid, status,
+			completed_at, due_at, time_zone, reminder_minutes, priority, position, list_id, assigner_id, assignment)
+		VALUES (now(), now(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at;`, td.Title, td.Description, td.UserID, td.Status, td.CompletedAt,
+		td.DueAt, td.TimeZone, td.ReminderMinutes, td.Priority, td.Position, td.ListID, td.AssignerID, td.Assignment)
+	if err := row.Scan(&td.ID, &td.CreatedAt, &td.UpdatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SqlDB) MoveToDo(userId uint64, taskId uint64, anchorId uint64, after bool) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebind(lockPositionsSQL), userId); err != nil {
+		return 0, err
+	}
+	var anchor, neighbour string
+	query, args := anchorPositionSQL(userId, anchorId)
+	err = tx.QueryRow(rebind(query), args...).Scan(&anchor)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	query, args = neighbourPositionSQL(userId, taskId, anchor, after)
+	err = tx.QueryRow(rebind(query), args...).Scan(&neighbour)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	query, args = movePositionSQL(userId, taskId, movedPosition(anchor, neighbour, after))
+	result, err := tx.Exec(rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
 }
 
+// UpdateToDo leaves empty fields unchanged.  A task that becomes done gets a
+// completed_at time, which is kept while it stays done and cleared otherwise.
+// Changing or clearing the due date, or the reminders, rearms the reminders.
 func (db *SqlDB) UpdateToDo(td *Todo) (int64, error) {
-	return 0, fmt.Errorf("not implemented")
+	access, args := taskAccessSQL(td.UserID, RoleEditor)
+	result, err := db.Exec(`UPDATE todos SET
+			title = COALESCE(NULLIF($1, ''), title),
+			status = COALESCE(NULLIF($2, ''), status),
+			completed_at = CASE
+				WHEN $2 = '' THEN completed_at
+				WHEN $2 = 'done' THEN COALESCE(completed_at, now())
+				ELSE NULL END,
+			due_at = CASE WHEN $10 THEN NULL ELSE COALESCE($4::timestamptz, due_at) END,
+			time_zone = COALESCE(NULLIF($5, ''), time_zone),
+			reminder_minutes = CASE WHEN $6 THEN $7::bigint[] ELSE reminder_minutes END,
+			reminded_at = CASE WHEN $4::timestamptz IS NOT NULL OR $6 OR $10 THEN NULL ELSE reminded_at END,
+			description = COALESCE(NULLIF($8, ''), description),
+			priority = COALESCE(NULLIF($9, 0), priority),
+			updated_at = now()
+		WHERE id = $3 AND deleted_at IS NULL AND `+rebindFrom(access, 10)+`;`,
+		append([]interface{}{td.Title, td.Status, td.ID, td.DueAt, td.TimeZone, td.ReminderMinutes != nil,
+			td.ReminderMinutes, td.Description, td.Priority, td.ClearDueAt && td.DueAt == nil}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (db *SqlDB) CreateUser(user *NewUser) error {
-	return fmt.Errorf("not implemented")
+	pending := user.Pending != nil && *user.Pending
+	_, err := db.Exec(`INSERT INTO users (created_at, updated_at, email, first_name, last_name, password, pending, verified_at)
+		VALUES (now(), now(), $1, $2, $3, $4, $5, $6);`,
+		strings.ToLower(user.Email), user.FirstName, user.LastName, user.Password, pending, user.VerifiedAt)
+	return err
 }
 
+// UpdateUser completes the registration of a pending user.  Empty fields are left unchanged.
 func (db *SqlDB) UpdateUser(user *NewUser) error {
-	return fmt.Errorf("not implemented")
+	_, err := db.Exec(`UPDATE users SET
+			first_name = COALESCE(NULLIF($1, ''), first_name),
+			last_name = COALESCE(NULLIF($2, ''), last_name),
+			password = COALESCE(NULLIF($3, ''), password),
+			verified_at = COALESCE($4, verified_at),
+			pending = false,
+			updated_at = now()
+		WHERE email = $5 AND deleted_at IS NULL;`,
+		user.FirstName, user.LastName, user.Password, user.VerifiedAt, strings.ToLower(user.Email))
+	return err
 }
 
 func (db *GormDB) CreateUser(user *NewUser) error {
 	u := User{NewUser: *user}
 	u.Email = strings.ToLower(u.Email)
+	if u.Pending == nil {
+		// Store false rather than NULL so that callers can dereference Pending.
+		Pending := false
+		u.Pending = &Pending
+	}
 	result := db.Create(&u)
 	return result.Error
 }
@@ -217,3 +616,601 @@ func (db *GormDB) UpdateUser(user *NewUser) error {
 	result := db.Where("email = ?", strings.ToLower(u.Email)).Updates(&u)
 	return result.Error
 }
+
+func (db *SqlDB) UpdatePassword(userId uint64, password string) error {
+	_, err := db.Exec("UPDATE users SET password = $1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL;",
+		password, userId)
+	return err
+}
+
+func (db *GormDB) UpdatePassword(userId uint64, password string) error {
+	result := db.Model(&User{}).Where("id = ?", userId).Update("password", password)
+	return result.Error
+}
+
+func (db *SqlDB) VerifyUser(userId uint64, at time.Time) (int64, error) {
+	result, err := db.Exec(rebind(verifyUserSQL), at, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) VerifyUser(userId uint64, at time.Time) (int64, error) {
+	result := db.Exec(verifyUserSQL, at, userId)
+	return result.RowsAffected, result.Error
+}
+
+func scanList(row scanner) (*List, error) {
+	list := &List{}
+	err := row.Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt, &list.DeletedAt, &list.Name, &list.UserID,
+		&list.ArchivedAt, &list.Role)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// listID returns the value of the list_id column for listId.
+func listID(listId uint64) *uint64 {
+	if listId == 0 {
+		return nil
+	}
+	return &listId
+}
+
+func (db *GormDB) SetToDoList(userId uint64, taskId uint64, listId uint64) (int64, error) {
+	query, args := setToDoListSQL(userId, taskId, listId)
+	result := db.Exec(query, args...)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) SetToDoList(userId uint64, taskId uint64, listId uint64) (int64, error) {
+	query, args := setToDoListSQL(userId, taskId, listId)
+	result, err := db.Exec(rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) CreateList(list *List) error {
+	result := db.Create(list)
+	return result.Error
+}
+
+func (db *SqlDB) CreateList(list *List) error {
+	row := db.QueryRow(`INSERT INTO lists (created_at, updated_at, name, userid)
+		VALUES (now(), now(), $1, $2)
+		RETURNING id, created_at, updated_at;`, list.Name, list.UserID)
+	return row.Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt)
+}
+
+func (db *GormDB) GetList(userId uint64, listId uint64) (*List, error) {
+	lists := []List{}
+	query, args := listsSQL(userId, "l.id = ?", listId)
+	result := db.Raw(query, args...).Scan(&lists)
+	if result.Error != nil || len(lists) == 0 {
+		return nil, result.Error
+	}
+	return &lists[0], nil
+}
+
+func (db *SqlDB) GetList(userId uint64, listId uint64) (*List, error) {
+	lists, err := db.queryLists(listsSQL(userId, "l.id = ?", listId))
+	if err != nil || len(lists) == 0 {
+		return nil, err
+	}
+	return &lists[0], nil
+}
+
+func (db *GormDB) GetLists(userId uint64, archived bool) ([]List, error) {
+	lists := []List{}
+	query, args := listsSQL(userId, "(? OR l.archived_at IS NULL)", archived)
+	result := db.Raw(query, args...).Scan(&lists)
+	return lists, result.Error
+}
+
+func (db *SqlDB) GetLists(userId uint64, archived bool) ([]List, error) {
+	return db.queryLists(listsSQL(userId, "(? OR l.archived_at IS NULL)", archived))
+}
+
+func (db *SqlDB) queryLists(query string, args []interface{}) ([]List, error) {
+	rows, err := db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []List{}
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, *list)
+	}
+	return lists, rows.Err()
+}
+
+func (db *GormDB) UpdateList(list *List) (int64, error) {
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if list.Name != "" {
+		updates["name"] = list.Name
+	}
+	owner, args := listOwnerSQL(list.UserID, list.ID)
+	result := db.Model(&List{}).Where(owner, args...).Updates(updates)
+	return result.RowsAffected, result.Error
+}
+
+// UpdateList leaves empty fields unchanged.
+func (db *SqlDB) UpdateList(list *List) (int64, error) {
+	owner, args := listOwnerSQL(list.UserID, list.ID)
+	result, err := db.Exec(`UPDATE lists SET
+			name = COALESCE(NULLIF($1, ''), name),
+			updated_at = now()
+		WHERE `+rebindFrom(owner, 1)+`;`, append([]interface{}{list.Name}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// archivedAt returns the value of the archived_at column of a list that is
+// archived or restored.  Archiving an archived list keeps the original time.
+func archivedAt(archived bool) interface{} {
+	if !archived {
+		return nil
+	}
+	return gorm.Expr("COALESCE(archived_at, ?)", time.Now())
+}
+
+func (db *GormDB) ArchiveList(userId uint64, listId uint64, archived bool) (int64, error) {
+	owner, args := listOwnerSQL(userId, listId)
+	result := db.Model(&List{}).Where(owner, args...).
+		Updates(map[string]interface{}{"archived_at": archivedAt(archived), "updated_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) ArchiveList(userId uint64, listId uint64, archived bool) (int64, error) {
+	owner, args := listOwnerSQL(userId, listId)
+	result, err := db.Exec(`UPDATE lists SET
+			archived_at = CASE WHEN $1 THEN COALESCE(archived_at, now()) END,
+			updated_at = now()
+		WHERE `+rebindFrom(owner, 1)+`;`, append([]interface{}{archived}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) DeleteList(userId uint64, listId uint64) (rows int64, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		owner, args := listOwnerSQL(userId, listId)
+		result := tx.Where(owner, args...).Delete(&List{})
+		rows = result.RowsAffected
+		if result.Error != nil || rows == 0 {
+			return result.Error
+		}
+		return tx.Exec(detachListSQL, listId).Error
+	})
+	return
+}
+
+func (db *SqlDB) DeleteList(userId uint64, listId uint64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	owner, args := listOwnerSQL(userId, listId)
+	result, err := tx.Exec(rebind("UPDATE lists SET deleted_at = now() WHERE "+owner+";"), args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return rows, err
+	}
+	if _, err = tx.Exec(rebind(detachListSQL), listId); err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+func (db *GormDB) TaskRole(userId uint64, taskId uint64) (string, error) {
+	var role sql.NullString
+	err := db.Raw(taskRoleSQL, userId, userId, userId, taskId).Row().Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role.String, err
+}
+
+func (db *SqlDB) TaskRole(userId uint64, taskId uint64) (string, error) {
+	var role sql.NullString
+	err := db.QueryRow(rebind(taskRoleSQL), userId, userId, userId, taskId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role.String, err
+}
+
+func (db *GormDB) ShareList(listId uint64, memberId uint64, role string) error {
+	return db.Exec(shareListSQL, listId, memberId, role).Error
+}
+
+func (db *SqlDB) ShareList(listId uint64, memberId uint64, role string) error {
+	_, err := db.Exec(rebind(shareListSQL), listId, memberId, role)
+	return err
+}
+
+func (db *GormDB) UnshareList(listId uint64, memberId uint64) (int64, error) {
+	result := db.Exec(unshareListSQL, listId, memberId)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) UnshareList(listId uint64, memberId uint64) (int64, error) {
+	result, err := db.Exec(rebind(unshareListSQL), listId, memberId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) GetListMembers(listId uint64) ([]ListMember, error) {
+	members := []ListMember{}
+	result := db.Raw(listMembersSQL, listId, listId).Scan(&members)
+	return members, result.Error
+}
+
+func (db *SqlDB) GetListMembers(listId uint64) ([]ListMember, error) {
+	rows, err := db.Query(rebind(listMembersSQL), listId, listId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []ListMember{}
+	for rows.Next() {
+		var m ListMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (db *GormDB) HasInvited(inviterId uint64, userId uint64) (bool, error) {
+	var invited bool
+	err := db.Raw(hasInvitedSQL, inviterId, userId, inviterId, userId).Row().Scan(&invited)
+	return invited, err
+}
+
+func (db *SqlDB) HasInvited(inviterId uint64, userId uint64) (bool, error) {
+	var invited bool
+	err := db.QueryRow(rebind(hasInvitedSQL), inviterId, userId, inviterId, userId).Scan(&invited)
+	return invited, err
+}
+
+func (db *GormDB) AnswerAssignment(userId uint64, taskId uint64, assignment string) (int64, error) {
+	result := db.Exec(answerAssignmentSQL, assignment, taskId, userId)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) AnswerAssignment(userId uint64, taskId uint64, assignment string) (int64, error) {
+	result, err := db.Exec(rebind(answerAssignmentSQL), assignment, taskId, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) GetUsers(filter UserFilter) ([]User, error) {
+	users := []User{}
+	where, args := userFilterSQL(filter)
+	tx := db.Where(where, args...).Order("id")
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+	result := tx.Find(&users)
+	return users, result.Error
+}
+
+func (db *SqlDB) GetUsers(filter UserFilter) ([]User, error) {
+	where, args := userFilterSQL(filter)
+	query := "SELECT " + userColumns + " FROM users WHERE " + where + " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(filter.Limit)
+	}
+	rows, err := db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (db *GormDB) SetUserRole(userId uint64, role string) (int64, error) {
+	result := db.Exec(setUserRoleSQL, role, userId)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) SetUserRole(userId uint64, role string) (int64, error) {
+	result, err := db.Exec(rebind(setUserRoleSQL), role, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) SuspendUser(userId uint64, at *time.Time) (int64, error) {
+	result := db.Exec(suspendUserSQL, at, userId)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) SuspendUser(userId uint64, at *time.Time) (int64, error) {
+	result, err := db.Exec(rebind(suspendUserSQL), at, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) DeleteUser(userId uint64) (int64, error) {
+	result := db.Where("id = ?", userId).Delete(&User{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteUser soft deletes the user, like GORM does for models with a DeletedAt field.
+func (db *SqlDB) DeleteUser(userId uint64) (int64, error) {
+	result, err := db.Exec("UPDATE users SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL;", userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) GetToDo(taskId uint64) (*Todo, error) {
+	td := &Todo{}
+	result := db.Where("id = ?", taskId).Limit(1).Find(td)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, result.Error
+	}
+	return td, nil
+}
+
+func (db *SqlDB) GetToDo(taskId uint64) (*Todo, error) {
+	td, err := scanTodo(db.QueryRow("SELECT "+todoColumns+" FROM todos WHERE id = $1 AND deleted_at IS NULL;", taskId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return td, err
+}
+
+func (db *SqlDB) ReadUserByID(userId uint64) (*User, error) {
+	user, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL;", userId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (db *GormDB) ReadUserByID(userId uint64) (*User, error) {
+	user := &User{}
+	result := db.Where("id = ?", userId).Limit(1).Find(user)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, result.Error
+	}
+	return user, nil
+}
+
+func (db *GormDB) SetTOTPSecret(userId uint64, secret string) (int64, error) {
+	result := db.Exec(setTOTPSecretSQL, secret, userId)
+	return result.RowsAffected, result.Error
+}
+
+func (db *SqlDB) SetTOTPSecret(userId uint64, secret string) (int64, error) {
+	result, err := db.Exec(rebind(setTOTPSecretSQL), secret, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *GormDB) EnableTOTP(userId uint64, at time.Time, recoveryCodes []string) (rows int64, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(enableTOTPSQL, at, userId)
+		rows = result.RowsAffected
+		if result.Error != nil || rows == 0 {
+			return result.Error
+		}
+		if err := tx.Exec(deleteRecoveryCodesSQL, userId).Error; err != nil {
+			return err
+		}
+		return tx.Exec(insertRecoveryCodesSQL, userId, pq.StringArray(recoveryCodes)).Error
+	})
+	return
+}
+
+func (db *SqlDB) EnableTOTP(userId uint64, at time.Time, recoveryCodes []string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(rebind(enableTOTPSQL), at, userId)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return rows, err
+	}
+	if _, err = tx.Exec(rebind(deleteRecoveryCodesSQL), userId); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(rebind(insertRecoveryCodesSQL), userId, pq.StringArray(recoveryCodes)); err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+func (db *GormDB) DisableTOTP(userId uint64) (rows int64, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(disableTOTPSQL, userId)
+		rows = result.RowsAffected
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Exec(deleteRecoveryCodesSQL, userId).Error
+	})
+	return
+}
+
+func (db *SqlDB) DisableTOTP(userId uint64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(rebind(disableTOTPSQL), userId)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(rebind(deleteRecoveryCodesSQL), userId); err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+func (db *GormDB) UseTOTPStep(userId uint64, step int64) (bool, error) {
+	result := db.Exec(useTOTPStepSQL, step, userId, step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *SqlDB) UseTOTPStep(userId uint64, step int64) (bool, error) {
+	result, err := db.Exec(rebind(useTOTPStepSQL), step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *GormDB) UseRecoveryCode(userId uint64, codeHash string) (bool, error) {
+	result := db.Exec(useRecoveryCodeSQL, userId, codeHash)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *SqlDB) UseRecoveryCode(userId uint64, codeHash string) (bool, error) {
+	result, err := db.Exec(rebind(useRecoveryCodeSQL), userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *GormDB) CreateAPIKey(key *APIKey) error {
+	return db.Create(key).Error
+}
+
+func (db *SqlDB) CreateAPIKey(key *APIKey) error {
+	row := db.QueryRow(`INSERT INTO api_keys (created_at, updated_at, user_id, name, prefix, hash, scopes, expires_at)
+		VALUES (now(), now(), $1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at;`, key.UserID, key.Name, key.Prefix, key.Hash, key.Scopes, key.ExpiresAt)
+	return row.Scan(&key.ID, &key.CreatedAt, &key.UpdatedAt)
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	key := &APIKey{}
+	err := row.Scan(&key.ID, &key.CreatedAt, &key.UpdatedAt, &key.DeletedAt, &key.UserID, &key.Name,
+		&key.Prefix, &key.Hash, &key.Scopes, &key.ExpiresAt, &key.LastUsedAt, &key.Role)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (db *GormDB) GetAPIKeys(userId uint64) ([]APIKey, error) {
+	keys := []APIKey{}
+	result := db.Raw(apiKeysSQL("k.user_id = ?"), userId).Scan(&keys)
+	return keys, result.Error
+}
+
+func (db *SqlDB) GetAPIKeys(userId uint64) ([]APIKey, error) {
+	rows, err := db.Query(rebind(apiKeysSQL("k.user_id = ?")), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (db *GormDB) FindAPIKey(hash string, now time.Time) (*APIKey, error) {
+	keys := []APIKey{}
+	result := db.Raw(apiKeysSQL(findAPIKeyCondition), hash, now).Scan(&keys)
+	if result.Error != nil || len(keys) == 0 {
+		return nil, result.Error
+	}
+	return &keys[0], nil
+}
+
+func (db *SqlDB) FindAPIKey(hash string, now time.Time) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(rebind(apiKeysSQL(findAPIKeyCondition)), hash, now))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (db *GormDB) TouchAPIKey(keyId uint64, at time.Time) error {
+	return db.Exec(touchAPIKeySQL, at, keyId).Error
+}
+
+func (db *SqlDB) TouchAPIKey(keyId uint64, at time.Time) error {
+	_, err := db.Exec(rebind(touchAPIKeySQL), at, keyId)
+	return err
+}
+
+func (db *GormDB) RevokeAPIKey(userId uint64, keyId uint64) (int64, error) {
+	result := db.Where("id = ? AND user_id = ?", keyId, userId).Delete(&APIKey{})
+	return result.RowsAffected, result.Error
+}
+
+// RevokeAPIKey soft deletes the key, like GORM does for models with a DeletedAt field.
+func (db *SqlDB) RevokeAPIKey(userId uint64, keyId uint64) (int64, error) {
+	result, err := db.Exec("UPDATE api_keys SET deleted_at = now() WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL;",
+		keyId, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
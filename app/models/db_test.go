@@ -0,0 +1,66 @@
+package models_test
+
+import (
+	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
+	"github.com/tintash-training/todo-api/app/models/datastoretest"
+	"os"
+	"testing"
+)
+
+// The Postgres backends are only tested when TODO_TEST_POSTGRES is set.  The
+// database described by the TODO_DB_* variables is migrated and then emptied
+// before every test.
+const truncate = "TRUNCATE users, todos, lists, list_members, recovery_codes, api_keys RESTART IDENTITY CASCADE;"
+
+func postgresConfig(t *testing.T, impl string) *config.DBConfig {
+	if os.Getenv("TODO_TEST_POSTGRES") == "" {
+		t.Skip("TODO_TEST_POSTGRES not set")
+	}
+	dbConfig := config.GetConf().DBConfig
+	dbConfig.Impl = impl
+
+	migrator, err := models.OpenMigrator(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	if _, err = migrator.Up(0, false); err != nil {
+		t.Fatal(err)
+	}
+	return dbConfig
+}
+
+func TestGormDB(t *testing.T) {
+	dbConfig := postgresConfig(t, "gorm")
+	datastoretest.Run(t, func(t *testing.T) models.Datastore {
+		ds, err := models.ConnectDS(dbConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := ds.(*models.GormDB)
+		if sqlDB, err := db.DB.DB(); err == nil {
+			t.Cleanup(func() { sqlDB.Close() })
+		}
+		if err = db.Exec(truncate).Error; err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	})
+}
+
+func TestSqlDB(t *testing.T) {
+	dbConfig := postgresConfig(t, "sql")
+	datastoretest.Run(t, func(t *testing.T) models.Datastore {
+		ds, err := models.ConnectDS(dbConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := ds.(*models.SqlDB)
+		t.Cleanup(func() { db.Close() })
+		if _, err = db.Exec(truncate); err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	})
+}
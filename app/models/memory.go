@@ -0,0 +1,985 @@
+package models
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDB is a Datastore kept in process memory.  It follows the same rules as
+// the Postgres backends, including soft deletes, and is safe for concurrent use.
+type MemoryDB struct {
+	mu    sync.RWMutex
+	users map[uint64]*User
+	todos map[uint64]*Todo
+	lists map[uint64]*List
+	// members maps list ids to the roles of the members by user id.
+	members map[uint64]map[uint64]string
+	// totpSteps holds the time step of the last code used by each user, and
+	// recoveryCodes the hashes of their recovery codes, true once used.
+	totpSteps     map[uint64]int64
+	recoveryCodes map[uint64]map[string]bool
+	apiKeys       map[uint64]*APIKey
+	lastUserID    uint64
+	lastTodoID    uint64
+	lastListID    uint64
+	lastAPIKeyID  uint64
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:   map[uint64]*User{},
+		todos:   map[uint64]*Todo{},
+		lists:   map[uint64]*List{},
+		members: map[uint64]map[uint64]string{},
+
+		totpSteps:     map[uint64]int64{},
+		recoveryCodes: map[uint64]map[string]bool{},
+		apiKeys:       map[uint64]*APIKey{},
+	}
+}
+
+func (db *MemoryDB) Ping() error {
+	return nil
+}
+
+func (db *MemoryDB) Close() error {
+	return nil
+}
+
+// copyUser returns a copy that does not share Pending with the stored user.
+func copyUser(u *User) *User {
+	user := *u
+	if u.Pending != nil {
+		Pending := *u.Pending
+		user.Pending = &Pending
+	}
+	if u.VerifiedAt != nil {
+		VerifiedAt := *u.VerifiedAt
+		user.VerifiedAt = &VerifiedAt
+	}
+	if u.SuspendedAt != nil {
+		SuspendedAt := *u.SuspendedAt
+		user.SuspendedAt = &SuspendedAt
+	}
+	if u.TOTPEnabledAt != nil {
+		TOTPEnabledAt := *u.TOTPEnabledAt
+		user.TOTPEnabledAt = &TOTPEnabledAt
+	}
+	return &user
+}
+
+func (db *MemoryDB) findUser(email string) *User {
+	for _, u := range db.users {
+		if u.Email == email && !u.DeletedAt.Valid {
+			return u
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) ReadUser(email string) (*User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	u := db.findUser(strings.ToLower(email))
+	if u == nil {
+		return nil, nil
+	}
+	return copyUser(u), nil
+}
+
+func (db *MemoryDB) CreateUser(user *NewUser) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := &User{NewUser: *user}
+	u.Email = strings.ToLower(u.Email)
+	// Like the unique index in Postgres, this includes soft deleted users.
+	for _, other := range db.users {
+		if other.Email == u.Email {
+			return fmt.Errorf("user %s already exists", u.Email)
+		}
+	}
+	Pending := user.Pending != nil && *user.Pending
+	u.Pending = &Pending
+	u.Role = UserRoleUser
+	db.lastUserID++
+	u.ID = db.lastUserID
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	db.users[u.ID] = u
+	return nil
+}
+
+func (db *MemoryDB) UpdateUser(user *NewUser) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.findUser(strings.ToLower(user.Email))
+	if u == nil {
+		return nil
+	}
+	if user.FirstName != "" {
+		u.FirstName = user.FirstName
+	}
+	if user.LastName != "" {
+		u.LastName = user.LastName
+	}
+	if user.Password != "" {
+		u.Password = user.Password
+	}
+	if user.VerifiedAt != nil {
+		u.VerifiedAt = user.VerifiedAt
+	}
+	Pending := false
+	u.Pending = &Pending
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (db *MemoryDB) UpdatePassword(userId uint64, password string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[userId]
+	if ok && !u.DeletedAt.Valid {
+		u.Password = password
+		u.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (db *MemoryDB) VerifyUser(userId uint64, at time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[userId]
+	if !ok || u.DeletedAt.Valid || u.VerifiedAt != nil {
+		return 0, nil
+	}
+	u.VerifiedAt = &at
+	u.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) SaveToDo(td *Todo) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lastTodoID++
+	td.ID = db.lastTodoID
+	td.CreatedAt = time.Now()
+	td.UpdatedAt = td.CreatedAt
+	td.prepareNew(td.CreatedAt)
+	last := ""
+	for _, other := range db.todos {
+		if db.taskRole(td.UserID, other) != "" && other.Position > last {
+			last = other.Position
+		}
+	}
+	td.Position = rankBetween(last, "")
+	stored := *td
+	db.todos[td.ID] = &stored
+	return nil
+}
+
+// listRole mirrors listAccessSQL.
+func (db *MemoryDB) listRole(userId uint64, list *List) string {
+	if list.DeletedAt.Valid {
+		return ""
+	}
+	if list.UserID == userId {
+		return RoleOwner
+	}
+	return db.members[list.ID][userId]
+}
+
+// taskRole mirrors taskAccessSQL.
+func (db *MemoryDB) taskRole(userId uint64, td *Todo) string {
+	if td.DeletedAt.Valid {
+		return ""
+	}
+	if td.ListID == nil {
+		if td.UserID == userId {
+			return RoleOwner
+		}
+		return ""
+	}
+	list, ok := db.lists[*td.ListID]
+	if !ok {
+		return ""
+	}
+	return db.listRole(userId, list)
+}
+
+// findTodo returns the task if it exists and the user has at least the role min on it.
+func (db *MemoryDB) findTodo(userId uint64, taskId uint64, min string) *Todo {
+	td, ok := db.todos[taskId]
+	if !ok || !RoleAtLeast(db.taskRole(userId, td), min) {
+		return nil
+	}
+	return td
+}
+
+func (db *MemoryDB) TaskRole(userId uint64, taskId uint64) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	td, ok := db.todos[taskId]
+	if !ok {
+		return "", nil
+	}
+	return db.taskRole(userId, td), nil
+}
+
+func (db *MemoryDB) UpdateToDo(td *Todo) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findTodo(td.UserID, td.ID, RoleEditor)
+	if stored == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	if td.Title != "" {
+		stored.Title = td.Title
+	}
+	if td.Description != "" {
+		stored.Description = td.Description
+	}
+	if td.Priority != 0 {
+		stored.Priority = td.Priority
+	}
+	if td.Status != "" {
+		stored.Status = td.Status
+		if td.Status != StatusDone {
+			stored.CompletedAt = nil
+		} else if stored.CompletedAt == nil {
+			stored.CompletedAt = &now
+		}
+	}
+	if td.DueAt != nil {
+		stored.DueAt = td.DueAt
+		stored.RemindedAt = nil
+	} else if td.ClearDueAt {
+		stored.DueAt = nil
+		stored.RemindedAt = nil
+	}
+	if td.TimeZone != "" {
+		stored.TimeZone = td.TimeZone
+	}
+	if td.ReminderMinutes != nil {
+		stored.ReminderMinutes = td.ReminderMinutes
+		stored.RemindedAt = nil
+	}
+	stored.UpdatedAt = now
+	return 1, nil
+}
+
+func (db *MemoryDB) MoveToDo(userId uint64, taskId uint64, anchorId uint64, after bool) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	anchor := db.findTodo(userId, anchorId, RoleViewer)
+	stored := db.findTodo(userId, taskId, RoleEditor)
+	if anchor == nil || stored == nil {
+		return 0, nil
+	}
+	// The neighbour is the closest task on the chosen side, by position and id,
+	// skipping the tasks at the position of the anchor.
+	var neighbour *Todo
+	for _, td := range db.todos {
+		if db.taskRole(userId, td) == "" || td.ID == taskId || td.Position == anchor.Position {
+			continue
+		}
+		side := compareKeys(td.Position, td.ID, anchor.Position, anchor.ID, !after)
+		if side > 0 && (neighbour == nil || compareKeys(td.Position, td.ID, neighbour.Position, neighbour.ID, !after) < 0) {
+			neighbour = td
+		}
+	}
+	next := ""
+	if neighbour != nil {
+		next = neighbour.Position
+	}
+	stored.Position = movedPosition(anchor.Position, next, after)
+	stored.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) AnswerAssignment(userId uint64, taskId uint64, assignment string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	td, ok := db.todos[taskId]
+	if !ok || td.DeletedAt.Valid || td.UserID != userId || td.Assignment != AssignmentPending {
+		return 0, nil
+	}
+	td.Assignment = assignment
+	td.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) DeleteToDo(userId uint64, taskId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findTodo(userId, taskId, RoleEditor)
+	if stored == nil {
+		return 0, nil
+	}
+	stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return 1, nil
+}
+
+func (db *MemoryDB) GetAllTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	if err := query.checkCursor(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	page := &TaskPage{Tasks: []Todo{}}
+	for _, td := range db.todos {
+		visible := db.taskRole(userId, td) != ""
+		if query.Delegated {
+			visible = !td.DeletedAt.Valid && td.AssignerID != nil && *td.AssignerID == userId
+		}
+		if visible && matchTask(td, query.TaskFilter) {
+			page.Tasks = append(page.Tasks, *td)
+		}
+	}
+	page.Total = int64(len(page.Tasks))
+
+	key := query.sortKey()
+	sort.Slice(page.Tasks, func(i, j int) bool {
+		return compareTasks(key, &page.Tasks[i], &page.Tasks[j], query.Descending) < 0
+	})
+	if c := query.Cursor; c != nil {
+		// The cursor stands for a task with the key and id it recorded.
+		var value interface{}
+		if c.Value != nil {
+			value, _ = parseKey(key, *c.Value)
+		}
+		start := sort.Search(len(page.Tasks), func(i int) bool {
+			td := &page.Tasks[i]
+			return compareKeys(key.value(td), td.ID, value, c.ID, query.Descending) > 0
+		})
+		page.Tasks = page.Tasks[start:]
+	}
+	if query.Limit > 0 && len(page.Tasks) > query.Limit+1 {
+		page.Tasks = page.Tasks[:query.Limit+1]
+	}
+	query.finishPage(page)
+	return page, nil
+}
+
+// SearchTasks falls back to substring matching, as MemoryDB has no full-text index.
+func (db *MemoryDB) SearchTasks(userId uint64, text string, limit int) ([]Todo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type hit struct {
+		td    Todo
+		score int
+	}
+	hits := []hit{}
+	if terms := searchTerms(text); len(terms) > 0 {
+		for _, td := range db.todos {
+			if db.taskRole(userId, td) == "" {
+				continue
+			}
+			if score, ok := matchSearch(td, terms); ok {
+				hits = append(hits, hit{*td, score})
+			}
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].td.ID < hits[j].td.ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	todos := make([]Todo, len(hits))
+	for i := range hits {
+		todos[i] = hits[i].td
+	}
+	return todos, nil
+}
+
+func compareTasks(key sortKey, a, b *Todo, descending bool) int {
+	return compareKeys(key.value(a), a.ID, key.value(b), b.ID, descending)
+}
+
+// compareKeys orders like taskPageSQL: missing keys last, then by key and id
+// in the requested direction.
+func compareKeys(a interface{}, aID uint64, b interface{}, bID uint64, descending bool) int {
+	if (a == nil) != (b == nil) {
+		if a == nil {
+			return 1
+		}
+		return -1
+	}
+	result := 0
+	if a != nil {
+		result = compareValues(a, b)
+	}
+	if result == 0 {
+		switch {
+		case aID < bID:
+			result = -1
+		case aID > bID:
+			result = 1
+		}
+	}
+	if descending {
+		return -result
+	}
+	return result
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		switch b := b.(time.Time); {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	case int64:
+		switch b := b.(int64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+// matchTask mirrors taskFilterSQL.
+func matchTask(td *Todo, filter TaskFilter) bool {
+	if filter.Status != "" && td.Status != filter.Status {
+		return false
+	}
+	if filter.Unfinished && !td.Unfinished() {
+		return false
+	}
+	if filter.Text != "" && !strings.Contains(strings.ToLower(td.Title), strings.ToLower(filter.Text)) {
+		return false
+	}
+	if filter.CreatedAfter != nil && td.CreatedAt.Before(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !td.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	if filter.DueAfter != nil && (td.DueAt == nil || td.DueAt.Before(*filter.DueAfter)) {
+		return false
+	}
+	if filter.DueBefore != nil && (td.DueAt == nil || !td.DueAt.Before(*filter.DueBefore)) {
+		return false
+	}
+	if filter.Assignment != "" && td.Assignment != filter.Assignment {
+		return false
+	}
+	if filter.ListID != nil {
+		if *filter.ListID == 0 {
+			if td.ListID != nil {
+				return false
+			}
+		} else if td.ListID == nil || *td.ListID != *filter.ListID {
+			return false
+		}
+	}
+	return true
+}
+
+// dueReminder mirrors dueRemindersSQL for one task.
+func dueReminder(td *Todo, now time.Time) (remindAt time.Time, due bool) {
+	if td.DeletedAt.Valid || td.DueAt == nil || !td.Unfinished() {
+		return
+	}
+	for _, minutes := range td.ReminderMinutes {
+		at := td.DueAt.Add(-time.Duration(minutes) * time.Minute)
+		if at.After(now) || (td.RemindedAt != nil && !at.After(*td.RemindedAt)) {
+			continue
+		}
+		if !due || at.After(remindAt) {
+			remindAt, due = at, true
+		}
+	}
+	return
+}
+
+func (db *MemoryDB) DueReminders(now time.Time) ([]Reminder, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	reminders := []Reminder{}
+	for _, td := range db.todos {
+		remindAt, due := dueReminder(td, now)
+		user, ok := db.users[td.UserID]
+		if !due || !ok || user.DeletedAt.Valid {
+			continue
+		}
+		reminders = append(reminders, Reminder{
+			TaskID:   td.ID,
+			UserID:   td.UserID,
+			Email:    user.Email,
+			Title:    td.Title,
+			DueAt:    *td.DueAt,
+			TimeZone: td.TimeZone,
+			RemindAt: remindAt,
+		})
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].RemindAt.Before(reminders[j].RemindAt) })
+	return reminders, nil
+}
+
+func (db *MemoryDB) ClaimReminder(taskId uint64, remindAt time.Time) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	td, ok := db.todos[taskId]
+	if !ok || td.DeletedAt.Valid || (td.RemindedAt != nil && !td.RemindedAt.Before(remindAt)) {
+		return false, nil
+	}
+	td.RemindedAt = &remindAt
+	return true, nil
+}
+
+// findList returns the list if it exists and the user has at least the role min on it.
+func (db *MemoryDB) findList(userId uint64, listId uint64, min string) *List {
+	list, ok := db.lists[listId]
+	if !ok || !RoleAtLeast(db.listRole(userId, list), min) {
+		return nil
+	}
+	return list
+}
+
+func (db *MemoryDB) SetToDoList(userId uint64, taskId uint64, listId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findTodo(userId, taskId, RoleEditor)
+	if stored == nil || (listId != 0 && db.findList(userId, listId, RoleEditor) == nil) {
+		return 0, nil
+	}
+	stored.ListID = listID(listId)
+	stored.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) CreateList(list *List) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lastListID++
+	list.ID = db.lastListID
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = list.CreatedAt
+	stored := *list
+	db.lists[list.ID] = &stored
+	return nil
+}
+
+func (db *MemoryDB) GetList(userId uint64, listId uint64) (*List, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	list := db.findList(userId, listId, RoleViewer)
+	if list == nil {
+		return nil, nil
+	}
+	found := *list
+	found.Role = db.listRole(userId, list)
+	return &found, nil
+}
+
+func (db *MemoryDB) GetLists(userId uint64, archived bool) ([]List, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	lists := []List{}
+	for _, list := range db.lists {
+		if role := db.listRole(userId, list); role != "" && (archived || list.ArchivedAt == nil) {
+			found := *list
+			found.Role = role
+			lists = append(lists, found)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	return lists, nil
+}
+
+func (db *MemoryDB) UpdateList(list *List) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findList(list.UserID, list.ID, RoleOwner)
+	if stored == nil {
+		return 0, nil
+	}
+	if list.Name != "" {
+		stored.Name = list.Name
+	}
+	stored.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) ArchiveList(userId uint64, listId uint64, archived bool) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findList(userId, listId, RoleOwner)
+	if stored == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	if !archived {
+		stored.ArchivedAt = nil
+	} else if stored.ArchivedAt == nil {
+		stored.ArchivedAt = &now
+	}
+	stored.UpdatedAt = now
+	return 1, nil
+}
+
+func (db *MemoryDB) DeleteList(userId uint64, listId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := db.findList(userId, listId, RoleOwner)
+	if stored == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	stored.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	for _, td := range db.todos {
+		if td.ListID != nil && *td.ListID == listId {
+			td.ListID = nil
+			td.UpdatedAt = now
+		}
+	}
+	return 1, nil
+}
+
+func (db *MemoryDB) ShareList(listId uint64, memberId uint64, role string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.members[listId] == nil {
+		db.members[listId] = map[uint64]string{}
+	}
+	db.members[listId][memberId] = role
+	return nil
+}
+
+func (db *MemoryDB) UnshareList(listId uint64, memberId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.members[listId][memberId]; !ok {
+		return 0, nil
+	}
+	delete(db.members[listId], memberId)
+	return 1, nil
+}
+
+func (db *MemoryDB) GetListMembers(listId uint64) ([]ListMember, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	members := []ListMember{}
+	add := func(userId uint64, role string) {
+		if user, ok := db.users[userId]; ok && !user.DeletedAt.Valid {
+			members = append(members, ListMember{UserID: userId, Email: user.Email, Role: role})
+		}
+	}
+	if list, ok := db.lists[listId]; ok {
+		add(list.UserID, RoleOwner)
+	}
+	for userId, role := range db.members[listId] {
+		add(userId, role)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members, nil
+}
+
+func (db *MemoryDB) HasInvited(inviterId uint64, userId uint64) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, td := range db.todos {
+		if !td.DeletedAt.Valid && td.AssignerID != nil && *td.AssignerID == inviterId && td.UserID == userId {
+			return true, nil
+		}
+	}
+	for listId, list := range db.lists {
+		if _, ok := db.members[listId][userId]; ok && !list.DeletedAt.Valid && list.UserID == inviterId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *MemoryDB) GetUsers(filter UserFilter) ([]User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	users := []User{}
+	text := strings.ToLower(filter.Text)
+	for _, u := range db.users {
+		if u.DeletedAt.Valid || !strings.Contains(u.Email, text) ||
+			(filter.Role != "" && u.Role != filter.Role) || u.ID <= filter.AfterID {
+			continue
+		}
+		users = append(users, *copyUser(u))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
+// liveUser returns the user with the id unless deleted.  The caller must hold mu.
+func (db *MemoryDB) liveUser(userId uint64) *User {
+	u, ok := db.users[userId]
+	if !ok || u.DeletedAt.Valid {
+		return nil
+	}
+	return u
+}
+
+func (db *MemoryDB) SetUserRole(userId uint64, role string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil {
+		return 0, nil
+	}
+	u.Role = role
+	u.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) SuspendUser(userId uint64, at *time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil {
+		return 0, nil
+	}
+	u.SuspendedAt = at
+	u.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) DeleteUser(userId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil {
+		return 0, nil
+	}
+	u.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return 1, nil
+}
+
+func (db *MemoryDB) GetToDo(taskId uint64) (*Todo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	td, ok := db.todos[taskId]
+	if !ok || td.DeletedAt.Valid {
+		return nil, nil
+	}
+	found := *td
+	return &found, nil
+}
+
+func (db *MemoryDB) ReadUserByID(userId uint64) (*User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	u := db.liveUser(userId)
+	if u == nil {
+		return nil, nil
+	}
+	return copyUser(u), nil
+}
+
+func (db *MemoryDB) SetTOTPSecret(userId uint64, secret string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil || u.TOTPEnabledAt != nil {
+		return 0, nil
+	}
+	u.TOTPSecret = secret
+	u.UpdatedAt = time.Now()
+	return 1, nil
+}
+
+func (db *MemoryDB) EnableTOTP(userId uint64, at time.Time, recoveryCodes []string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil || u.TOTPSecret == "" || u.TOTPEnabledAt != nil {
+		return 0, nil
+	}
+	u.TOTPEnabledAt = &at
+	u.UpdatedAt = time.Now()
+	codes := map[string]bool{}
+	for _, code := range recoveryCodes {
+		codes[code] = false
+	}
+	db.recoveryCodes[userId] = codes
+	return 1, nil
+}
+
+func (db *MemoryDB) DisableTOTP(userId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.liveUser(userId)
+	if u == nil {
+		return 0, nil
+	}
+	u.TOTPSecret = ""
+	u.TOTPEnabledAt = nil
+	u.UpdatedAt = time.Now()
+	delete(db.totpSteps, userId)
+	delete(db.recoveryCodes, userId)
+	return 1, nil
+}
+
+func (db *MemoryDB) UseTOTPStep(userId uint64, step int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	last, used := db.totpSteps[userId]
+	if db.liveUser(userId) == nil || (used && last >= step) {
+		return false, nil
+	}
+	db.totpSteps[userId] = step
+	return true, nil
+}
+
+func (db *MemoryDB) UseRecoveryCode(userId uint64, codeHash string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	used, ok := db.recoveryCodes[userId][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	db.recoveryCodes[userId][codeHash] = true
+	return true, nil
+}
+
+// copyAPIKey returns a copy of a stored key with the role of its user.  The
+// caller must hold mu.
+func (db *MemoryDB) copyAPIKey(k *APIKey) *APIKey {
+	key := *k
+	key.Scopes = append(pq.StringArray{}, k.Scopes...)
+	if k.ExpiresAt != nil {
+		ExpiresAt := *k.ExpiresAt
+		key.ExpiresAt = &ExpiresAt
+	}
+	if k.LastUsedAt != nil {
+		LastUsedAt := *k.LastUsedAt
+		key.LastUsedAt = &LastUsedAt
+	}
+	if u, ok := db.users[k.UserID]; ok {
+		key.Role = u.Role
+	}
+	return &key
+}
+
+func (db *MemoryDB) CreateAPIKey(key *APIKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Like the unique index in Postgres, this includes revoked keys.
+	for _, other := range db.apiKeys {
+		if other.Hash == key.Hash {
+			return fmt.Errorf("API key %s already exists", key.Prefix)
+		}
+	}
+	db.lastAPIKeyID++
+	key.ID = db.lastAPIKeyID
+	key.CreatedAt = time.Now()
+	key.UpdatedAt = key.CreatedAt
+	db.apiKeys[key.ID] = db.copyAPIKey(key)
+	return nil
+}
+
+func (db *MemoryDB) GetAPIKeys(userId uint64) ([]APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, k := range db.apiKeys {
+		if k.UserID == userId && !k.DeletedAt.Valid {
+			keys = append(keys, *db.copyAPIKey(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (db *MemoryDB) FindAPIKey(hash string, now time.Time) (*APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, k := range db.apiKeys {
+		if k.Hash != hash || k.DeletedAt.Valid || (k.ExpiresAt != nil && !k.ExpiresAt.After(now)) {
+			continue
+		}
+		if u := db.liveUser(k.UserID); u == nil || u.SuspendedAt != nil {
+			return nil, nil
+		}
+		return db.copyAPIKey(k), nil
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) TouchAPIKey(keyId uint64, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if k, ok := db.apiKeys[keyId]; ok {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
+
+func (db *MemoryDB) RevokeAPIKey(userId uint64, keyId uint64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	k, ok := db.apiKeys[keyId]
+	if !ok || k.UserID != userId || k.DeletedAt.Valid {
+		return 0, nil
+	}
+	k.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return 1, nil
+}
@@ -0,0 +1,13 @@
+package models_test
+
+import (
+	"github.com/tintash-training/todo-api/app/models"
+	"github.com/tintash-training/todo-api/app/models/datastoretest"
+	"testing"
+)
+
+func TestMemoryDB(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) models.Datastore {
+		return models.NewMemoryDB()
+	})
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/tintash-training/todo-api/app/config"
+	"time"
+)
+
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL
+	);`
+
+// Arbitrary key of the advisory lock that serializes concurrent migration runs.
+const migrationLock = 7271937
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts the migrations tracked in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func OpenMigrator(config *config.DBConfig) (*Migrator, error) {
+	switch config.Impl {
+	case "gorm", "sql":
+	default:
+		return nil, fmt.Errorf("the %s datastore has no schema to migrate", config.Impl)
+	}
+	db, err := sql.Open("postgres", config.DataSourceName())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	// Look before querying, so that Status and dry runs leave a fresh database untouched.
+	var exists bool
+	err := m.db.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL;").Scan(&exists)
+	if err != nil || !exists {
+		return map[int64]time.Time{}, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Status lists every known migration and when it was applied, if it was.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	result := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// Up applies pending migrations up to and including version target, or all of
+// them if target is 0.  With dryRun nothing is changed.  It returns the
+// migrations that were, or with dryRun would be, applied.
+func (m *Migrator) Up(target int64, dryRun bool) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	result := []Migration{}
+	for _, migration := range m.migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if !dryRun {
+			err = m.run(migration, true)
+			if err != nil {
+				return result, fmt.Errorf("migration %d (%s): %v", migration.Version, migration.Name, err)
+			}
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// Down reverts the last steps applied migrations.  With dryRun nothing is
+// changed.  It returns the migrations that were, or would be, reverted.
+func (m *Migrator) Down(steps int, dryRun bool) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	result := []Migration{}
+	for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if !dryRun {
+			err = m.run(migration, false)
+			if err != nil {
+				return result, fmt.Errorf("migration %d (%s): %v", migration.Version, migration.Name, err)
+			}
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// run applies or reverts one migration in a transaction together with its
+// bookkeeping, so that a failed migration leaves no trace.
+func (m *Migrator) run(migration Migration, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1);", migrationLock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(migrationsSchema); err != nil {
+		return err
+	}
+	// Another process may have run the migration while we waited for the lock.
+	var done bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1);",
+		migration.Version).Scan(&done)
+	if err != nil {
+		return err
+	}
+	if done == up {
+		return nil
+	}
+
+	if up {
+		_, err = tx.Exec(migration.Up)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now());",
+				migration.Version, migration.Name)
+		}
+	} else {
+		_, err = tx.Exec(migration.Down)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1;", migration.Version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
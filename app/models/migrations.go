@@ -0,0 +1,249 @@
+package models
+
+// Migration is one step of the database schema.  Migrations are applied in
+// order of Version, which must never change once a migration has been released.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrations lists every schema change.  Append new migrations to the end.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users and todos",
+		// IF NOT EXISTS adopts databases that were set up by GORM's AutoMigrate,
+		// or by SqlDB.CreateTables, whose users only had an id, an email and a
+		// password, and whose todos only a userid and a title.
+		Up: `
+			CREATE TABLE IF NOT EXISTS users (
+				id bigserial PRIMARY KEY,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz,
+				email text,
+				first_name text,
+				last_name text,
+				password text,
+				pending boolean
+			);
+			ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS created_at timestamptz,
+				ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+				ADD COLUMN IF NOT EXISTS deleted_at timestamptz,
+				ADD COLUMN IF NOT EXISTS first_name text,
+				ADD COLUMN IF NOT EXISTS last_name text,
+				ADD COLUMN IF NOT EXISTS pending boolean;
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+			CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+			CREATE TABLE IF NOT EXISTS todos (
+				id bigserial PRIMARY KEY,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz,
+				title text,
+				userid bigint
+			);
+			ALTER TABLE todos
+				ADD COLUMN IF NOT EXISTS id bigserial PRIMARY KEY,
+				ADD COLUMN IF NOT EXISTS created_at timestamptz,
+				ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+				ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+			CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos (deleted_at);`,
+		Down: `
+			DROP TABLE todos;
+			DROP TABLE users;`,
+	},
+	{
+		Version: 2,
+		Name:    "add task status",
+		Up: `
+			ALTER TABLE todos ADD COLUMN status text NOT NULL DEFAULT 'open';
+			ALTER TABLE todos ADD COLUMN completed_at timestamptz;
+			CREATE INDEX idx_todos_userid_status ON todos (userid, status);`,
+		Down: `
+			DROP INDEX idx_todos_userid_status;
+			ALTER TABLE todos DROP COLUMN completed_at;
+			ALTER TABLE todos DROP COLUMN status;`,
+	},
+	{
+		Version: 3,
+		Name:    "add task due dates and reminders",
+		Up: `
+			ALTER TABLE todos ADD COLUMN due_at timestamptz;
+			ALTER TABLE todos ADD COLUMN time_zone text NOT NULL DEFAULT '';
+			ALTER TABLE todos ADD COLUMN reminder_minutes bigint[];
+			ALTER TABLE todos ADD COLUMN reminded_at timestamptz;
+			CREATE INDEX idx_todos_userid_due_at ON todos (userid, due_at);`,
+		Down: `
+			DROP INDEX idx_todos_userid_due_at;
+			ALTER TABLE todos DROP COLUMN reminded_at;
+			ALTER TABLE todos DROP COLUMN reminder_minutes;
+			ALTER TABLE todos DROP COLUMN time_zone;
+			ALTER TABLE todos DROP COLUMN due_at;`,
+	},
+	{
+		Version: 4,
+		Name:    "add task descriptions and full-text search",
+		// The simple configuration does not stem words, so that prefix queries
+		// match what was typed whatever the language of the task.
+		Up: `
+			ALTER TABLE todos ADD COLUMN description text NOT NULL DEFAULT '';
+			ALTER TABLE todos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('simple', description), 'B')) STORED;
+			CREATE INDEX idx_todos_search ON todos USING gin (search);`,
+		Down: `
+			DROP INDEX idx_todos_search;
+			ALTER TABLE todos DROP COLUMN search;
+			ALTER TABLE todos DROP COLUMN description;`,
+	},
+	{
+		Version: 5,
+		Name:    "add task priority and position",
+		// Existing tasks keep the order of their ids.  Their positions have a
+		// fixed width so that they compare like the ids, and end in i, like
+		// the positions of rankBetween, so that there is room before them.
+		Up: `
+			ALTER TABLE todos ADD COLUMN priority integer NOT NULL DEFAULT 2;
+			ALTER TABLE todos ADD COLUMN position text COLLATE "C" NOT NULL DEFAULT '';
+			UPDATE todos SET position = 'i' || lpad(to_hex(n), 12, '0') || 'i'
+				FROM (SELECT id AS todo_id, row_number() OVER (PARTITION BY userid ORDER BY id) AS n FROM todos) AS ranks
+				WHERE id = todo_id;
+			CREATE INDEX idx_todos_userid_position ON todos (userid, position);`,
+		Down: `
+			DROP INDEX idx_todos_userid_position;
+			ALTER TABLE todos DROP COLUMN position;
+			ALTER TABLE todos DROP COLUMN priority;`,
+	},
+	{
+		Version: 6,
+		Name:    "add task lists",
+		Up: `
+			CREATE TABLE lists (
+				id bigserial PRIMARY KEY,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz,
+				name text NOT NULL,
+				userid bigint NOT NULL,
+				archived_at timestamptz
+			);
+			CREATE INDEX idx_lists_userid ON lists (userid);
+			CREATE INDEX idx_lists_deleted_at ON lists (deleted_at);
+			ALTER TABLE todos ADD COLUMN list_id bigint REFERENCES lists (id);
+			CREATE INDEX idx_todos_list_id ON todos (list_id);`,
+		Down: `
+			ALTER TABLE todos DROP COLUMN list_id;
+			DROP TABLE lists;`,
+	},
+	{
+		Version: 7,
+		Name:    "add list members",
+		// The user who created a list, lists.userid, owns it without being a member.
+		Up: `
+			CREATE TABLE list_members (
+				list_id bigint NOT NULL REFERENCES lists (id),
+				user_id bigint NOT NULL,
+				role text NOT NULL,
+				created_at timestamptz,
+				updated_at timestamptz,
+				PRIMARY KEY (list_id, user_id)
+			);
+			CREATE INDEX idx_list_members_user_id ON list_members (user_id);`,
+		Down: `
+			DROP TABLE list_members;`,
+	},
+	{
+		Version: 8,
+		Name:    "add task assignments",
+		Up: `
+			ALTER TABLE todos ADD COLUMN assigner_id bigint;
+			ALTER TABLE todos ADD COLUMN assignment text NOT NULL DEFAULT '';
+			CREATE INDEX idx_todos_assigner_id ON todos (assigner_id) WHERE assigner_id IS NOT NULL;`,
+		Down: `
+			DROP INDEX idx_todos_assigner_id;
+			ALTER TABLE todos DROP COLUMN assignment;
+			ALTER TABLE todos DROP COLUMN assigner_id;`,
+	},
+	{
+		Version: 9,
+		Name:    "add email verification",
+		// Accounts that exist already count as verified.  Pending users prove
+		// their address with the invitation they were emailed.
+		Up: `
+			ALTER TABLE users ADD COLUMN verified_at timestamptz;
+			UPDATE users SET verified_at = created_at WHERE pending IS NOT TRUE;`,
+		Down: `
+			ALTER TABLE users DROP COLUMN verified_at;`,
+	},
+	{
+		Version: 10,
+		Name:    "add user roles and suspension",
+		Up: `
+			ALTER TABLE users ADD COLUMN role text NOT NULL DEFAULT 'user';
+			ALTER TABLE users ADD COLUMN suspended_at timestamptz;`,
+		Down: `
+			ALTER TABLE users DROP COLUMN suspended_at;
+			ALTER TABLE users DROP COLUMN role;`,
+	},
+	{
+		Version: 11,
+		Name:    "add two-factor authentication",
+		// totp_last_step is the time step of the last code used, which cannot
+		// be used again.  Recovery codes are stored as SHA-256 hashes.
+		Up: `
+			ALTER TABLE users ADD COLUMN totp_secret text NOT NULL DEFAULT '';
+			ALTER TABLE users ADD COLUMN totp_enabled_at timestamptz;
+			ALTER TABLE users ADD COLUMN totp_last_step bigint;
+			CREATE TABLE recovery_codes (
+				user_id bigint NOT NULL REFERENCES users (id),
+				code_hash text NOT NULL,
+				used_at timestamptz,
+				PRIMARY KEY (user_id, code_hash)
+			);`,
+		Down: `
+			DROP TABLE recovery_codes;
+			ALTER TABLE users DROP COLUMN totp_last_step;
+			ALTER TABLE users DROP COLUMN totp_enabled_at;
+			ALTER TABLE users DROP COLUMN totp_secret;`,
+	},
+	{
+		Version: 12,
+		Name:    "add API keys",
+		// Keys are stored as SHA-256 hashes.  Revoked keys are soft deleted.
+		Up: `
+			CREATE TABLE api_keys (
+				id bigserial PRIMARY KEY,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz,
+				user_id bigint NOT NULL REFERENCES users (id),
+				name text NOT NULL,
+				prefix text NOT NULL,
+				hash text NOT NULL,
+				expires_at timestamptz,
+				last_used_at timestamptz
+			);
+			CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
+			CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
+			CREATE INDEX idx_api_keys_deleted_at ON api_keys (deleted_at);`,
+		Down: `
+			DROP TABLE api_keys;`,
+	},
+	{
+		Version: 13,
+		Name:    "add API key scopes",
+		// Existing keys keep every scope that the role of their user allows.
+		Up: `
+			ALTER TABLE api_keys ADD COLUMN scopes text[] NOT NULL DEFAULT '{}';
+			UPDATE api_keys k SET scopes = CASE WHEN u.role IN ('support', 'admin')
+				THEN '{tasks:read,tasks:write,account,admin}'::text[]
+				ELSE '{tasks:read,tasks:write,account}'::text[] END
+				FROM users u WHERE u.id = k.user_id;`,
+		Down: `
+			ALTER TABLE api_keys DROP COLUMN scopes;`,
+	},
+}
@@ -1,16 +1,103 @@
 package models
 
 import (
+	"fmt"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
 	"time"
 )
 
+// Lifecycle states of a task.
+const (
+	StatusOpen       = "open"
+	StatusInProgress = "in-progress"
+	StatusDone       = "done"
+	StatusCancelled  = "cancelled"
+)
+
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusOpen, StatusInProgress, StatusDone, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// States of the assignment of a task that one user assigned to another.  Tasks
+// that were not assigned have none.
+const (
+	AssignmentPending  = "pending"
+	AssignmentAccepted = "accepted"
+	AssignmentDeclined = "declined"
+)
+
+func ValidAssignment(assignment string) bool {
+	switch assignment {
+	case AssignmentPending, AssignmentAccepted, AssignmentDeclined:
+		return true
+	}
+	return false
+}
+
+// Priorities of a task, from lowest to highest.
+const (
+	PriorityLow    = 1
+	PriorityNormal = 2
+	PriorityHigh   = 3
+	PriorityUrgent = 4
+)
+
+// Roles of the members of a list, from the least to the most privileged.  Viewers
+// can read the tasks of a list, editors can also change them, and owners can
+// also manage the list and its members.  The user who creates a list is its
+// owner, and every user owns the tasks they keep outside of lists.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+var roles = []string{RoleViewer, RoleEditor, RoleOwner}
+
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func ValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+// RoleAtLeast reports whether role grants the rights of min.  The empty role
+// grants nothing.
+func RoleAtLeast(role, min string) bool {
+	return ValidRole(role) && roleRank(role) >= roleRank(min)
+}
+
+// Roles of users across the application.  Support staff may look at any user
+// and task, admins may also change them.
+const (
+	UserRoleUser    = "user"
+	UserRoleSupport = "support"
+	UserRoleAdmin   = "admin"
+)
+
+func ValidUserRole(role string) bool {
+	return role == UserRoleUser || role == UserRoleSupport || role == UserRoleAdmin
+}
+
 type NewUser struct {
 	Email     string `json:"email" gorm:"uniqueIndex"`
 	FirstName string `json:"first-name"`
 	LastName  string `json:"last-name"`
 	Password  string `json:"password"`
 	Pending   *bool  `json:"-"`
+	// VerifiedAt is when the user proved to own the email address, or nil.
+	VerifiedAt *time.Time `json:"-"`
 }
 
 type User struct {
@@ -19,11 +106,94 @@ type User struct {
 	UpdatedAt time.Time      `json:"-"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 	NewUser
+	// Role is one of the UserRole constants.
+	Role string `gorm:"default:user" json:"role"`
+	// SuspendedAt is when an admin suspended the user, or nil.
+	SuspendedAt *time.Time `json:"suspended-at,omitempty"`
+	// TOTPSecret is the secret of two-factor authentication, which is only
+	// required once TOTPEnabledAt is set.
+	TOTPSecret    string     `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at" json:"-"`
+}
+
+// UserFilter selects users for the admin API.
+type UserFilter struct {
+	// Text matches part of the email address.
+	Text string
+	Role string
+	// AfterID continues from the user with that id, in order of ids.
+	AfterID uint64
+	// Limit is the maximum number of users.  Zero means no limit.
+	Limit int
+}
+
+// APIKey is a personal API key, which authenticates the requests of its user
+// like an access token.  Only the hash of the key is stored.
+type APIKey struct {
+	ID        uint64         `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created-at"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	UserID    uint64         `json:"-"`
+	Name      string         `json:"name"`
+	// Prefix is the start of the key, by which users tell their keys apart.
+	Prefix string `json:"prefix"`
+	Hash   string `gorm:"uniqueIndex" json:"-"`
+	// Scopes limit what the key may be used for.
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	ExpiresAt  *time.Time     `json:"expires-at,omitempty"`
+	LastUsedAt *time.Time     `json:"last-used-at,omitempty"`
+	// Role is the role of the user, as read by Datastore.FindAPIKey.
+	Role string `gorm:"->" json:"-"`
 }
 
 type NewTodo struct {
-	ID    uint64 `json:"-"`
-	Title string `json:"title"`
+	ID          uint64 `json:"-"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// Status defaults to StatusOpen when a task is created and is left unchanged by an empty update.
+	Status string `json:"status"`
+	// Priority defaults to PriorityNormal when a task is created and is left unchanged by zero.
+	Priority int `json:"priority"`
+	// ListID is the list the task belongs to, if any.  It is set when the task
+	// is created and changed by Datastore.SetToDoList.
+	ListID *uint64 `json:"list-id,omitempty"`
+	// DueAt is an RFC 3339 time, which carries its UTC offset.  TimeZone optionally
+	// names the IANA zone the deadline was set in, e.g. "Europe/Berlin".
+	DueAt    *time.Time `json:"due-at,omitempty"`
+	TimeZone string     `json:"time-zone,omitempty"`
+	// ClearDueAt removes the due date in an update, since an empty DueAt
+	// leaves it unchanged.
+	ClearDueAt bool `gorm:"-" json:"clear-due-at,omitempty"`
+	// ReminderMinutes lists how long before DueAt the owner is emailed.  Unlike
+	// the other fields, an empty list in an update removes every reminder.
+	ReminderMinutes pq.Int64Array `gorm:"type:bigint[]" json:"reminder-minutes,omitempty"`
+}
+
+const maxReminderMinutes = 366 * 24 * 60
+
+// Validate checks the fields supplied by a client.  Empty fields are valid.
+func (td *NewTodo) Validate() error {
+	if td.Status != "" && !ValidStatus(td.Status) {
+		return fmt.Errorf("invalid status: %s", td.Status)
+	}
+	if td.TimeZone != "" {
+		if _, err := time.LoadLocation(td.TimeZone); err != nil {
+			return fmt.Errorf("invalid time-zone: %s", td.TimeZone)
+		}
+	}
+	if td.ClearDueAt && td.DueAt != nil {
+		return fmt.Errorf("due-at and clear-due-at are exclusive")
+	}
+	if td.Priority < 0 || td.Priority > PriorityUrgent {
+		return fmt.Errorf("invalid priority: %d", td.Priority)
+	}
+	for _, minutes := range td.ReminderMinutes {
+		if minutes < 0 || minutes > maxReminderMinutes {
+			return fmt.Errorf("invalid reminder-minutes: %d", minutes)
+		}
+	}
+	return nil
 }
 
 type Todo struct {
@@ -33,6 +203,122 @@ type Todo struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 	NewTodo
 	UserID uint64 `gorm:"column:userid" gorm:"index" json:"userid"`
+	// Position orders the tasks of a user manually.  New tasks go last.
+	Position string `json:"position"`
+	// AssignerID is the user who assigned the task to UserID, if any.  The
+	// assignee answers the assignment by accepting or declining it.
+	AssignerID *uint64 `json:"assigner-id,omitempty"`
+	Assignment string  `json:"assignment,omitempty"`
+	// CompletedAt is set when the task becomes done and cleared when it is reopened.
+	CompletedAt *time.Time `json:"completed-at,omitempty"`
+	// RemindedAt is the time of the last reminder sent.  It is cleared when the
+	// due date or the reminders change.
+	RemindedAt *time.Time `json:"-"`
+}
+
+// Unfinished reports whether the task still needs to be worked on.
+func (td *Todo) Unfinished() bool {
+	return td.Status == StatusOpen || td.Status == StatusInProgress
+}
+
+// prepareNew fills in the defaults of a task about to be saved.
+func (td *Todo) prepareNew(now time.Time) {
+	if td.Status == "" {
+		td.Status = StatusOpen
+	}
+	if td.Priority == 0 {
+		td.Priority = PriorityNormal
+	}
+	if td.Status == StatusDone && td.CompletedAt == nil {
+		td.CompletedAt = &now
+	}
+}
+
+// TaskFilter restricts the tasks returned by Datastore.GetAllTasks.  Empty fields match every task.
+type TaskFilter struct {
+	Status string
+	// Unfinished restricts the result to open and in-progress tasks.
+	Unfinished bool
+	// Text matches tasks whose title contains it, ignoring case.
+	Text string
+	// The After bounds are inclusive and the Before bounds exclusive.  Due date
+	// bounds exclude tasks without a due date.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	DueAfter      *time.Time
+	DueBefore     *time.Time
+	// ListID restricts the result to the tasks of a list, or with 0 to the
+	// tasks that belong to no list.
+	ListID *uint64
+	// Delegated selects the tasks the user assigned to others instead of the
+	// tasks the user may access.
+	Delegated  bool
+	Assignment string
+}
+
+// Reminder is a reminder that is due to be emailed to the owner of a task.
+type Reminder struct {
+	TaskID   uint64
+	UserID   uint64
+	Email    string
+	Title    string
+	DueAt    time.Time
+	TimeZone string
+	// RemindAt is when the reminder was due.  Earlier reminders of the same task
+	// that were missed are folded into the latest one.
+	RemindAt time.Time
+}
+
+type NewList struct {
+	Name string `json:"name"`
+}
+
+// Validate checks the fields supplied by a client.  An empty name is only valid in updates.
+func (l *NewList) Validate() error {
+	if len(l.Name) > maxListName {
+		return fmt.Errorf("name is longer than %d bytes", maxListName)
+	}
+	return nil
+}
+
+const maxListName = 200
+
+// List groups the tasks of a user, e.g. by project.
+type List struct {
+	ID        uint64         `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	NewList
+	UserID uint64 `gorm:"column:userid" json:"userid"`
+	// ArchivedAt is set while the list is archived.  Archived lists keep their
+	// tasks but are left out of list-lists unless asked for.
+	ArchivedAt *time.Time `json:"archived-at,omitempty"`
+	// Role is the role of the user who read the list.
+	Role string `gorm:"->" json:"role,omitempty"`
+}
+
+// ListMember is a user a list is shared with, or its owner.
+type ListMember struct {
+	UserID uint64 `json:"userid"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// ListShare shares a list with the user registered with Email.
+type ListShare struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (s *ListShare) Validate() error {
+	if s.Email == "" {
+		return fmt.Errorf("email is required")
+	}
+	if !ValidRole(s.Role) {
+		return fmt.Errorf("invalid role: %s", s.Role)
+	}
+	return nil
 }
 
 type AssignedTodo struct {
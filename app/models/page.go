@@ -0,0 +1,169 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Sort orders of Datastore.GetAllTasks.  Ties are broken by task id, and tasks
+// without a value for the sort key come last in either direction.
+const (
+	SortCreated  = "created"
+	SortUpdated  = "updated"
+	SortDue      = "due"
+	SortPriority = "priority"
+	SortPosition = "position"
+)
+
+const (
+	timeKey = iota
+	intKey
+	stringKey
+)
+
+type sortKey struct {
+	column string
+	kind   int
+	// value returns the key of a task, or nil if the task has none.
+	value func(td *Todo) interface{}
+}
+
+var taskSortKeys = map[string]sortKey{
+	SortCreated: {"created_at", timeKey, func(td *Todo) interface{} { return td.CreatedAt }},
+	SortUpdated: {"updated_at", timeKey, func(td *Todo) interface{} { return td.UpdatedAt }},
+	SortDue: {"due_at", timeKey, func(td *Todo) interface{} {
+		if td.DueAt == nil {
+			return nil
+		}
+		return *td.DueAt
+	}},
+	SortPriority: {"priority", intKey, func(td *Todo) interface{} { return int64(td.Priority) }},
+	SortPosition: {"position", stringKey, func(td *Todo) interface{} { return td.Position }},
+}
+
+func ValidSort(sort string) bool {
+	_, ok := taskSortKeys[sort]
+	return ok
+}
+
+var ErrInvalidCursor = errors.New("invalid page token")
+
+// TaskCursor marks the position after the last task of a page.
+type TaskCursor struct {
+	Sort       string  `json:"s"`
+	Descending bool    `json:"d,omitempty"`
+	Value      *string `json:"v,omitempty"`
+	ID         uint64  `json:"i"`
+}
+
+// TaskQuery selects a page of tasks.
+type TaskQuery struct {
+	TaskFilter
+	// Sort is one of the Sort constants.  Empty means SortCreated.
+	Sort       string
+	Descending bool
+	// Limit is the maximum number of tasks of the page.  Zero means no limit.
+	Limit int
+	// Cursor continues from a previous page.  It must have the same sort order.
+	Cursor *TaskCursor
+}
+
+type TaskPage struct {
+	Tasks []Todo `json:"tasks"`
+	// Total counts the tasks that match the filter on all pages.
+	Total int64 `json:"total"`
+	// NextPage is the page token of the next page, if there is one.
+	NextPage string `json:"next-page,omitempty"`
+}
+
+func (q *TaskQuery) sortKey() sortKey {
+	if key, ok := taskSortKeys[q.Sort]; ok {
+		return key
+	}
+	return taskSortKeys[SortCreated]
+}
+
+func (q *TaskQuery) sortName() string {
+	if q.Sort == "" {
+		return SortCreated
+	}
+	return q.Sort
+}
+
+// Encode returns the opaque page token of the cursor.
+func (c *TaskCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeTaskCursor(token string) (*TaskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &TaskCursor{}
+	if err = json.Unmarshal(data, c); err != nil || !ValidSort(c.Sort) {
+		return nil, ErrInvalidCursor
+	}
+	if c.Value != nil {
+		if _, err = parseKey(taskSortKeys[c.Sort], *c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+func formatKey(value interface{}) *string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339Nano)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case string:
+		s = v
+	}
+	return &s
+}
+
+func parseKey(key sortKey, s string) (interface{}, error) {
+	switch key.kind {
+	case timeKey:
+		return time.Parse(time.RFC3339Nano, s)
+	case intKey:
+		return strconv.ParseInt(s, 10, 64)
+	case stringKey:
+		return s, nil
+	}
+	return nil, ErrInvalidCursor
+}
+
+// checkCursor reports whether the cursor of the query belongs to its sort order.
+func (q *TaskQuery) checkCursor() error {
+	if q.Cursor != nil && (q.Cursor.Sort != q.sortName() || q.Cursor.Descending != q.Descending) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// finishPage cuts the tasks, which hold up to one more than the limit, to the
+// limit and sets the token of the next page if there is one.
+func (q *TaskQuery) finishPage(page *TaskPage) {
+	if q.Limit <= 0 || len(page.Tasks) <= q.Limit {
+		return
+	}
+	page.Tasks = page.Tasks[:q.Limit]
+	last := &page.Tasks[q.Limit-1]
+	cursor := TaskCursor{
+		Sort:       q.sortName(),
+		Descending: q.Descending,
+		Value:      formatKey(q.sortKey().value(last)),
+		ID:         last.ID,
+	}
+	page.NextPage = cursor.Encode()
+}
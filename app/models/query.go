@@ -0,0 +1,345 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The Postgres backends share the SQL that selects tasks, so that they cannot
+// drift apart.  It is written with ? placeholders as GORM expects them, and
+// rebind converts it for database/sql.
+
+// listAccessSQL returns the condition selecting the lists on which the user has
+// at least the role min.  It leaves out deleted lists.
+func listAccessSQL(userId uint64, min string) (string, []interface{}) {
+	granting := []string{}
+	for _, role := range roles {
+		if RoleAtLeast(role, min) {
+			granting = append(granting, "'"+role+"'")
+		}
+	}
+	return "deleted_at IS NULL AND (userid = ? OR id IN (SELECT list_id FROM list_members " +
+			"WHERE user_id = ? AND role IN (" + strings.Join(granting, ", ") + ")))",
+		[]interface{}{userId, userId}
+}
+
+// taskAccessSQL returns the condition selecting the tasks on which the user has
+// at least the role min: the tasks of the lists the user may access that way,
+// and the user's own tasks outside of lists.
+func taskAccessSQL(userId uint64, min string) (string, []interface{}) {
+	lists, args := listAccessSQL(userId, min)
+	return "((list_id IS NULL AND userid = ?) OR list_id IN (SELECT id FROM lists WHERE " + lists + "))",
+		append([]interface{}{userId}, args...)
+}
+
+// taskFilterSQL returns the condition selecting the tasks visible to userId that match filter.
+func taskFilterSQL(userId uint64, filter TaskFilter) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleViewer)
+	if filter.Delegated {
+		access, args = "assigner_id = ?", []interface{}{userId}
+	}
+	conditions := []string{access, "deleted_at IS NULL"}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Unfinished {
+		conditions = append(conditions, "status IN (?, ?)")
+		args = append(args, StatusOpen, StatusInProgress)
+	}
+	if filter.Text != "" {
+		conditions = append(conditions, `title ILIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(filter.Text)+"%")
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.CreatedBefore)
+	}
+	if filter.DueAfter != nil {
+		conditions = append(conditions, "due_at >= ?")
+		args = append(args, *filter.DueAfter)
+	}
+	if filter.DueBefore != nil {
+		conditions = append(conditions, "due_at < ?")
+		args = append(args, *filter.DueBefore)
+	}
+	if filter.Assignment != "" {
+		conditions = append(conditions, "assignment = ?")
+		args = append(args, filter.Assignment)
+	}
+	if filter.ListID != nil {
+		if *filter.ListID == 0 {
+			conditions = append(conditions, "list_id IS NULL")
+		} else {
+			conditions = append(conditions, "list_id = ?")
+			args = append(args, *filter.ListID)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// taskPageSQL returns the condition and the order of the page of tasks selected
+// by query.  Tasks without a sort key come last, whatever the direction.
+func taskPageSQL(userId uint64, query TaskQuery) (string, []interface{}, string) {
+	where, args := taskFilterSQL(userId, query.TaskFilter)
+	column := query.sortKey().column
+	dir, op := "ASC", ">"
+	if query.Descending {
+		dir, op = "DESC", "<"
+	}
+	order := fmt.Sprintf("%s IS NULL, %s %s, id %s", column, column, dir, dir)
+
+	if c := query.Cursor; c != nil {
+		if c.Value == nil {
+			where += fmt.Sprintf(" AND (%s IS NULL AND id %s ?)", column, op)
+			args = append(args, c.ID)
+		} else {
+			// DecodeTaskCursor has checked that the value parses.
+			value, _ := parseKey(query.sortKey(), *c.Value)
+			where += fmt.Sprintf(" AND (%[1]s IS NULL OR %[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op)
+			args = append(args, value, value, c.ID)
+		}
+	}
+	return where, args, order
+}
+
+// rebind replaces the ? placeholders of query with $1, $2 and so on.
+func rebind(query string) string {
+	return rebindFrom(query, 0)
+}
+
+// rebindFrom numbers the placeholders from n+1, for queries that continue a
+// query with n arguments.
+func rebindFrom(query string, n int) string {
+	var b strings.Builder
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// dueRemindersSQL selects, for every unfinished task with a due reminder that
+// has not been sent, the latest such reminder.
+const dueRemindersSQL = `
+	SELECT t.id AS task_id, t.userid AS user_id, u.email, t.title, t.due_at, t.time_zone,
+		max(t.due_at - m.minutes * interval '1 minute') AS remind_at
+	FROM todos t
+	JOIN users u ON u.id = t.userid AND u.deleted_at IS NULL
+	CROSS JOIN LATERAL unnest(t.reminder_minutes) AS m(minutes)
+	WHERE t.deleted_at IS NULL AND t.due_at IS NOT NULL AND t.status IN ('open', 'in-progress')
+		AND t.due_at - m.minutes * interval '1 minute' <= ?
+		AND (t.reminded_at IS NULL OR t.due_at - m.minutes * interval '1 minute' > t.reminded_at)
+	GROUP BY t.id, u.email
+	ORDER BY remind_at`
+
+// claimReminderSQL records that the reminder due at remind_at is being sent.  It
+// affects no row if another process claimed the reminder first.
+const claimReminderSQL = `
+	UPDATE todos SET reminded_at = ?
+	WHERE id = ? AND deleted_at IS NULL AND (reminded_at IS NULL OR reminded_at < ?)`
+
+// lockPositionsSQL locks the row of a user for the rest of the transaction, so
+// that tasks of the user are added and moved one at a time.  Locking the last
+// task alone would not do, since a task inserted meanwhile is not seen.
+const lockPositionsSQL = "SELECT id FROM users WHERE id = ? FOR UPDATE"
+
+// lastPositionSQL selects the position of the last task visible to a user.  It
+// selects no row if there is none.
+func lastPositionSQL(userId uint64) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleViewer)
+	return "SELECT position FROM todos WHERE deleted_at IS NULL AND " + access +
+		" ORDER BY position DESC LIMIT 1 FOR UPDATE", args
+}
+
+// anchorPositionSQL selects the position of the task that another one is moved
+// next to.
+func anchorPositionSQL(userId uint64, anchorId uint64) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleViewer)
+	return "SELECT position FROM todos WHERE id = ? AND deleted_at IS NULL AND " + access + " FOR UPDATE",
+		append([]interface{}{anchorId}, args...)
+}
+
+// neighbourPositionSQL selects the position of the task visible to the user
+// that comes after the anchor in the direction of the move, leaving out the
+// task that is moved.  Tasks at the same position as the anchor are skipped,
+// since there is no room between them.
+func neighbourPositionSQL(userId uint64, taskId uint64, anchor string, after bool) (string, []interface{}) {
+	op, dir := moveDirection(after)
+	access, args := taskAccessSQL(userId, RoleViewer)
+	return fmt.Sprintf(`
+		SELECT position FROM todos
+		WHERE deleted_at IS NULL AND %s AND id <> ? AND position %s ?
+		ORDER BY position %s, id %s LIMIT 1 FOR UPDATE`, access, op, dir, dir),
+		append(args, taskId, anchor)
+}
+
+func movePositionSQL(userId uint64, taskId uint64, position string) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleEditor)
+	return "UPDATE todos SET position = ?, updated_at = now() WHERE id = ? AND deleted_at IS NULL AND " + access,
+		append([]interface{}{position, taskId}, args...)
+}
+
+// setToDoListSQL moves a task into a list the user may edit.  A task moved
+// out of its list becomes an own task of the user who created it.
+func setToDoListSQL(userId uint64, taskId uint64, listId uint64) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleEditor)
+	if listId == 0 {
+		return "UPDATE todos SET list_id = NULL, updated_at = now() " +
+				"WHERE id = ? AND deleted_at IS NULL AND " + access,
+			append([]interface{}{taskId}, args...)
+	}
+	lists, listArgs := listAccessSQL(userId, RoleEditor)
+	return "UPDATE todos SET list_id = ?, updated_at = now() " +
+			"WHERE id = ? AND deleted_at IS NULL AND " + access + " AND ? IN (SELECT id FROM lists WHERE " + lists + ")",
+		append(append(append([]interface{}{listId, taskId}, args...), listId), listArgs...)
+}
+
+// detachListSQL takes the tasks out of a deleted list.
+const detachListSQL = `
+	UPDATE todos SET list_id = NULL, updated_at = now() WHERE list_id = ?`
+
+// taskRoleSQL selects the role of a user on a task, which is NULL if the user
+// may not access it.
+const taskRoleSQL = `
+	SELECT CASE
+		WHEN t.list_id IS NULL THEN CASE WHEN t.userid = ? THEN 'owner' END
+		WHEN l.userid = ? THEN 'owner'
+		ELSE m.role END
+	FROM todos t
+	LEFT JOIN lists l ON l.id = t.list_id AND l.deleted_at IS NULL
+	LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = ?
+	WHERE t.id = ? AND t.deleted_at IS NULL`
+
+// listsSQL selects the lists the user may access, with the role of the user,
+// that match condition.
+func listsSQL(userId uint64, condition string, args ...interface{}) (string, []interface{}) {
+	return `
+		SELECT l.id, l.created_at, l.updated_at, l.deleted_at, l.name, l.userid, l.archived_at,
+			CASE WHEN l.userid = ? THEN 'owner' ELSE m.role END AS role
+		FROM lists l
+		LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = ?
+		WHERE l.deleted_at IS NULL AND (l.userid = ? OR m.role IS NOT NULL) AND ` + condition + `
+		ORDER BY l.id`, append([]interface{}{userId, userId, userId}, args...)
+}
+
+// listOwnerSQL returns the condition selecting a list that the user owns.
+func listOwnerSQL(userId uint64, listId uint64) (string, []interface{}) {
+	access, args := listAccessSQL(userId, RoleOwner)
+	return "id = ? AND " + access, append([]interface{}{listId}, args...)
+}
+
+// listMembersSQL selects the owner and the members of a list.
+const listMembersSQL = `
+	SELECT u.id AS user_id, u.email, 'owner' AS role
+	FROM lists l JOIN users u ON u.id = l.userid AND u.deleted_at IS NULL
+	WHERE l.id = ?
+	UNION ALL
+	SELECT u.id, u.email, m.role
+	FROM list_members m JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+	WHERE m.list_id = ?
+	ORDER BY email`
+
+// shareListSQL adds a member to a list or changes the role of a member.
+const shareListSQL = `
+	INSERT INTO list_members (list_id, user_id, role, created_at, updated_at)
+	VALUES (?, ?, ?, now(), now())
+	ON CONFLICT (list_id, user_id) DO UPDATE SET role = EXCLUDED.role, updated_at = now()`
+
+const unshareListSQL = `
+	DELETE FROM list_members WHERE list_id = ? AND user_id = ?`
+
+// hasInvitedSQL selects whether a user has assigned a task to another one or
+// shared a list with them.
+const hasInvitedSQL = `
+	SELECT EXISTS (SELECT 1 FROM todos WHERE assigner_id = ? AND userid = ? AND deleted_at IS NULL)
+		OR EXISTS (SELECT 1 FROM lists l JOIN list_members m ON m.list_id = l.id
+			WHERE l.userid = ? AND m.user_id = ? AND l.deleted_at IS NULL)`
+
+// answerAssignmentSQL records the answer of the assignee to a pending assignment.
+const answerAssignmentSQL = `
+	UPDATE todos SET assignment = ?, updated_at = now()
+	WHERE id = ? AND userid = ? AND assignment = 'pending' AND deleted_at IS NULL`
+
+const verifyUserSQL = `
+	UPDATE users SET verified_at = ?, updated_at = now()
+	WHERE id = ? AND verified_at IS NULL AND deleted_at IS NULL`
+
+// userFilterSQL returns the condition selecting the users that match filter.
+func userFilterSQL(filter UserFilter) (string, []interface{}) {
+	conditions, args := []string{"deleted_at IS NULL"}, []interface{}{}
+	if filter.Text != "" {
+		conditions = append(conditions, `email ILIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(filter.Text)+"%")
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.AfterID != 0 {
+		conditions = append(conditions, "id > ?")
+		args = append(args, filter.AfterID)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+const setUserRoleSQL = `
+	UPDATE users SET role = ?, updated_at = now() WHERE id = ? AND deleted_at IS NULL`
+
+const suspendUserSQL = `
+	UPDATE users SET suspended_at = ?, updated_at = now() WHERE id = ? AND deleted_at IS NULL`
+
+const setTOTPSecretSQL = `
+	UPDATE users SET totp_secret = ?, updated_at = now()
+	WHERE id = ? AND totp_enabled_at IS NULL AND deleted_at IS NULL`
+
+const enableTOTPSQL = `
+	UPDATE users SET totp_enabled_at = ?, updated_at = now()
+	WHERE id = ? AND totp_secret <> '' AND totp_enabled_at IS NULL AND deleted_at IS NULL`
+
+const disableTOTPSQL = `
+	UPDATE users SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = NULL, updated_at = now()
+	WHERE id = ? AND deleted_at IS NULL`
+
+const deleteRecoveryCodesSQL = `
+	DELETE FROM recovery_codes WHERE user_id = ?`
+
+const insertRecoveryCodesSQL = `
+	INSERT INTO recovery_codes (user_id, code_hash) SELECT ?, unnest(?::text[])`
+
+// useTOTPStepSQL affects no row if the step, or a later one, was used already.
+const useTOTPStepSQL = `
+	UPDATE users SET totp_last_step = ?
+	WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?) AND deleted_at IS NULL`
+
+const useRecoveryCodeSQL = `
+	UPDATE recovery_codes SET used_at = now() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+
+// apiKeysSQL selects the API keys that have not been revoked and match the
+// condition, with the role of their user.
+func apiKeysSQL(condition string) string {
+	return `
+	SELECT k.id, k.created_at, k.updated_at, k.deleted_at, k.user_id, k.name, k.prefix, k.hash,
+		k.scopes, k.expires_at, k.last_used_at, u.role
+	FROM api_keys k JOIN users u ON u.id = k.user_id
+	WHERE k.deleted_at IS NULL AND ` + condition + `
+	ORDER BY k.id`
+}
+
+// findAPIKeyCondition matches the key with a hash that works at a time.
+const findAPIKeyCondition = `k.hash = ? AND (k.expires_at IS NULL OR k.expires_at > ?)
+		AND u.deleted_at IS NULL AND u.suspended_at IS NULL`
+
+const touchAPIKeySQL = `
+	UPDATE api_keys SET last_used_at = ? WHERE id = ?`
@@ -0,0 +1,59 @@
+package models
+
+import "strings"
+
+// Positions order the tasks of a user manually.  They are strings of base 36
+// digits compared byte by byte, which is why the position column has the C
+// collation.  There is always room between two positions, so moving a task
+// only rewrites the position of that task.  Positions never end in the lowest
+// digit, which would leave no room before them.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rankBetween returns a position between before and after.  An empty before
+// means the start and an empty after the end of the list.  It returns an empty
+// string if after does not sort above before, as there is no room then.
+func rankBetween(before, after string) string {
+	if after != "" && after <= before {
+		return ""
+	}
+	var rank []byte
+	bounded := after != ""
+	for i := 0; ; i++ {
+		lo := 0
+		if i < len(before) {
+			lo = strings.IndexByte(rankDigits, before[i])
+		}
+		hi := len(rankDigits)
+		if bounded && i < len(after) {
+			hi = strings.IndexByte(rankDigits, after[i])
+		}
+		if hi-lo > 1 {
+			return string(append(rank, rankDigits[(lo+hi)/2]))
+		}
+		rank = append(rank, rankDigits[lo])
+		if hi > lo {
+			// Whatever follows, the rank stays below after.
+			bounded = false
+		}
+	}
+}
+
+// moveDirection returns the comparison and the order that find the neighbour
+// on the side of the anchor a task is moved to.
+func moveDirection(after bool) (string, string) {
+	if after {
+		return ">", "ASC"
+	}
+	return "<", "DESC"
+}
+
+// movedPosition returns the position of a task moved next to anchor, on the
+// side of neighbour, which is empty if the anchor is first or last.  Tasks
+// tied with the anchor are never the neighbour, so a task moved next to one of
+// them goes past them all.
+func movedPosition(anchor, neighbour string, after bool) string {
+	if after {
+		return rankBetween(anchor, neighbour)
+	}
+	return rankBetween(neighbour, anchor)
+}
@@ -0,0 +1,49 @@
+package models
+
+import "testing"
+
+func TestRankBetween(t *testing.T) {
+	for _, tt := range []struct{ before, after string }{
+		{"", ""},
+		{"", "i"},
+		{"i", ""},
+		{"i", "j"},
+		{"i", "i1"},
+		{"", "01"},
+		{"", "001"},
+		{"z", ""},
+		{"zz", ""},
+		{"a0001", "a001"},
+		{"i00000000001i", "i00000000002i"},
+	} {
+		rank := rankBetween(tt.before, tt.after)
+		if rank <= tt.before || (tt.after != "" && rank >= tt.after) || rank[len(rank)-1] == '0' {
+			t.Errorf("rankBetween(%q, %q) = %q", tt.before, tt.after, rank)
+		}
+	}
+
+	// There is no room between equal bounds, nor below before.
+	for _, tt := range []struct{ before, after string }{
+		{"i", "i"},
+		{"i1", "i"},
+		{"j", "i"},
+	} {
+		if rank := rankBetween(tt.before, tt.after); rank != "" {
+			t.Errorf("rankBetween(%q, %q) = %q, want none", tt.before, tt.after, rank)
+		}
+	}
+
+	// Moving tasks to the same place over and over keeps the order.
+	before, after := "i", "j"
+	for i := 0; i < 100; i++ {
+		rank := rankBetween(before, after)
+		if rank <= before || rank >= after {
+			t.Fatalf("rankBetween(%q, %q) = %q", before, after, rank)
+		}
+		if i%2 == 0 {
+			before = rank
+		} else {
+			after = rank
+		}
+	}
+}
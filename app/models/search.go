@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// searchTerms splits text into the lowercase words that are searched for.
+// Everything but letters and digits separates words, so the terms can be put
+// into a tsquery without quoting.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tsQuery matches the words that start with each of the terms.
+func tsQuery(terms []string) string {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	return strings.Join(prefixes, " & ")
+}
+
+// searchLimit returns the argument of a LIMIT clause.  LIMIT NULL means no limit.
+func searchLimit(limit int) interface{} {
+	if limit <= 0 {
+		return nil
+	}
+	return limit
+}
+
+// searchTasksSQL selects the tasks visible to a user that match the terms,
+// ranked by relevance.  Matches in the title weigh more than those in the
+// description.
+func searchTasksSQL(userId uint64, terms []string, limit int) (string, []interface{}) {
+	access, args := taskAccessSQL(userId, RoleViewer)
+	return `
+		SELECT ` + todoColumns + ` FROM todos, to_tsquery('simple', ?) AS query
+		WHERE deleted_at IS NULL AND ` + access + ` AND search @@ query
+		ORDER BY ts_rank(search, query) DESC, id
+		LIMIT ?`, append(append([]interface{}{tsQuery(terms)}, args...), searchLimit(limit))
+}
+
+// matchSearch is the fallback of the in-process backend.  Every term must be a
+// substring of the title or the description.  The score counts the terms found
+// in the title.
+func matchSearch(td *Todo, terms []string) (score int, match bool) {
+	title := strings.ToLower(td.Title)
+	description := strings.ToLower(td.Description)
+	for _, term := range terms {
+		switch {
+		case strings.Contains(title, term):
+			score++
+		case !strings.Contains(description, term):
+			return 0, false
+		}
+	}
+	return score, true
+}
@@ -0,0 +1,149 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/tintash-training/todo-api/app/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+	"strings"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+type Hasher struct {
+	config *config.PasswordConfig
+}
+
+func CreateHasher(config *config.PasswordConfig) (*Hasher, error) {
+	switch config.Algorithm {
+	case Bcrypt:
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		if config.Argon2Time < 1 || config.Argon2Memory < 8*config.Argon2Threads || config.Argon2Threads < 1 {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		// argon2Params narrows them, and a value that wraps would panic in argon2.
+		if config.Argon2Threads > math.MaxUint8 || uint64(config.Argon2Time) > math.MaxUint32 || uint64(config.Argon2Memory) > math.MaxUint32 {
+			return nil, fmt.Errorf("argon2id parameters out of range")
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm: %s", config.Algorithm)
+	}
+	return &Hasher{config: config}, nil
+}
+
+// Hash encodes the password with the configured algorithm and cost.
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.config.Algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.config.BcryptCost)
+		return string(hash), err
+	default:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		p := h.argon2Params()
+		key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+			p.memory, p.time, p.threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+}
+
+// Verify compares the password with a stored hash.  Rows written before passwords
+// were hashed hold the plaintext, which is still accepted.  rehash reports that the
+// stored value is plaintext or was produced with other settings than the current
+// ones, and should be replaced by Hash(password) once the password matched.
+func (h *Hasher) Verify(password, stored string) (match bool, rehash bool, err error) {
+	switch {
+	case stored == "":
+		// Pending users have no password yet.
+		return false, false, nil
+	case strings.HasPrefix(stored, "$2"):
+		err = bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(stored))
+		if err != nil {
+			return false, false, err
+		}
+		return true, h.config.Algorithm != Bcrypt || cost != h.config.BcryptCost, nil
+	case strings.HasPrefix(stored, argon2idPrefix):
+		p, salt, key, err := decodeArgon2id(stored)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, h.config.Algorithm != Argon2id || p != h.argon2Params(), nil
+	default:
+		match = subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+		return match, match, nil
+	}
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func (h *Hasher) argon2Params() argon2Params {
+	return argon2Params{
+		memory:  uint32(h.config.Argon2Memory),
+		time:    uint32(h.config.Argon2Time),
+		threads: uint8(h.config.Argon2Threads),
+	}
+}
+
+// decodeArgon2id parses the PHC string format $argon2id$v=19$m=..,t=..,p=..$salt$key
+func decodeArgon2id(stored string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		err = ErrMalformedHash
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		err = ErrMalformedHash
+		return
+	}
+	// argon2.IDKey panics on parameters that CreateHasher would refuse.
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil ||
+		p.time < 1 || p.threads < 1 || p.memory < 8*uint32(p.threads) {
+		err = ErrMalformedHash
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		err = ErrMalformedHash
+		return
+	}
+	return
+}
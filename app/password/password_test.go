@@ -0,0 +1,113 @@
+package password
+
+import (
+	"github.com/tintash-training/todo-api/app/config"
+	"testing"
+)
+
+func newTestHasher(t *testing.T, algorithm string, cost int) *Hasher {
+	t.Helper()
+	h, err := CreateHasher(&config.PasswordConfig{
+		Algorithm:     algorithm,
+		BcryptCost:    cost,
+		Argon2Time:    cost,
+		Argon2Memory:  64,
+		Argon2Threads: 1,
+	})
+	if err != nil {
+		t.Fatalf("CreateHasher: %v", err)
+	}
+	return h
+}
+
+func TestCreateHasher(t *testing.T) {
+	for _, c := range []config.PasswordConfig{
+		{Algorithm: "md5"},
+		{Algorithm: Bcrypt, BcryptCost: 3},
+		{Algorithm: Bcrypt, BcryptCost: 32},
+		{Algorithm: Argon2id, Argon2Time: 0, Argon2Memory: 64, Argon2Threads: 1},
+		{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 7, Argon2Threads: 1},
+		{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 0},
+		{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 8 * 256, Argon2Threads: 256},
+		{Algorithm: Argon2id, Argon2Time: 1 << 32, Argon2Memory: 64, Argon2Threads: 1},
+		{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 1 << 32, Argon2Threads: 1},
+	} {
+		c := c
+		if _, err := CreateHasher(&c); err == nil {
+			t.Errorf("CreateHasher(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	bcrypt4, bcrypt5 := newTestHasher(t, Bcrypt, 4), newTestHasher(t, Bcrypt, 5)
+	argon1, argon2 := newTestHasher(t, Argon2id, 1), newTestHasher(t, Argon2id, 2)
+	hash := func(h *Hasher) string {
+		t.Helper()
+		stored, err := h.Hash("secret")
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+		return stored
+	}
+	bcryptHash, argonHash := hash(bcrypt4), hash(argon1)
+
+	for _, tt := range []struct {
+		name          string
+		hasher        *Hasher
+		password      string
+		stored        string
+		match, rehash bool
+	}{
+		{"bcrypt", bcrypt4, "secret", bcryptHash, true, false},
+		{"bcrypt with a wrong password", bcrypt4, "Secret", bcryptHash, false, false},
+		{"bcrypt with another cost", bcrypt5, "secret", bcryptHash, true, true},
+		{"bcrypt moving to argon2id", argon1, "secret", bcryptHash, true, true},
+		{"argon2id", argon1, "secret", argonHash, true, false},
+		{"argon2id with a wrong password", argon1, "Secret", argonHash, false, false},
+		{"argon2id with other parameters", argon2, "secret", argonHash, true, true},
+		{"argon2id moving to bcrypt", bcrypt4, "secret", argonHash, true, true},
+		{"plaintext", bcrypt4, "secret", "secret", true, true},
+		{"plaintext with a wrong password", argon1, "Secret", "secret", false, false},
+		{"no password", bcrypt4, "", "", false, false},
+		{"no password with a password", argon1, "secret", "", false, false},
+	} {
+		match, rehash, err := tt.hasher.Verify(tt.password, tt.stored)
+		if err != nil || match != tt.match || rehash != tt.rehash {
+			t.Errorf("%s: got %v, %v, %v, want %v, %v", tt.name, match, rehash, err, tt.match, tt.rehash)
+		}
+	}
+
+	// Hashes are salted.
+	if other := hash(argon1); other == argonHash {
+		t.Errorf("Hash returned the same argon2id hash twice")
+	}
+}
+
+func TestDecodeArgon2id(t *testing.T) {
+	if _, _, _, err := decodeArgon2id("$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5"); err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	for _, stored := range []string{
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5$extra",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$version$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=7,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$not base64!",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+	} {
+		if _, _, _, err := decodeArgon2id(stored); err != ErrMalformedHash {
+			t.Errorf("decodeArgon2id(%q): got %v, want ErrMalformedHash", stored, err)
+		}
+		// Verify reports the malformed hash rather than panicking or matching.
+		if match, _, err := newTestHasher(t, Argon2id, 1).Verify("secret", stored); match || err == nil {
+			t.Errorf("Verify with %q: got %v, %v, want an error", stored, match, err)
+		}
+	}
+}
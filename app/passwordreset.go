@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type passwordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// allowReset counts a password reset attempt under key.  It answers the
+// request itself once there were more than limit attempts in the window.
+func (app *App) allowReset(c *gin.Context, key string, limit int) bool {
+	allowed, err := app.auth.Allow(key, int64(limit), app.config.AuthConfig.ResetRateWindow)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, "too many password reset attempts, please try again later")
+		return false
+	}
+	return true
+}
+
+// sendPasswordResetEmail emails the user a token to set a new password with.
+// Tokens sent before stop working.
+func (app *App) sendPasswordResetEmail(user *models.User) error {
+	err := app.auth.RevokeActionTokens(authentication.ActionResetPassword, user.ID)
+	if err != nil {
+		return err
+	}
+	ttl := app.config.AuthConfig.PasswordResetTTL
+	token, err := app.auth.CreateActionToken(authentication.ActionResetPassword, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Someone asked to reset the password of your account.  If it was not you, ignore this email.\n\n"+
+		"Reset your password within %s with this token:\n\n%s\n", ttl, token)
+	return app.sendEmail(user.Email, "Reset your password", body)
+}
+
+// ForgotPassword emails a password reset token.  It answers the same whether
+// or not the email address is registered, so as not to disclose which are.
+func (app *App) ForgotPassword(c *gin.Context) {
+	var inv invitee
+	if err := c.ShouldBindJSON(&inv); err != nil || inv.Email == "" {
+		c.JSON(http.StatusUnprocessableEntity, "email is required")
+		return
+	}
+	if !app.allowReset(c, "reset-ip:"+c.ClientIP(), app.config.AuthConfig.ResetIPLimit) ||
+		!app.allowReset(c, "reset-email:"+strings.ToLower(inv.Email), app.config.AuthConfig.ResetEmailLimit) {
+		return
+	}
+
+	user, err := app.db.ReadUser(inv.Email)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	// Pending users have no password yet.  They register with their invitation.
+	if user != nil && !*user.Pending {
+		if err = app.sendPasswordResetEmail(user); err != nil {
+			glog.Error("Error sending password reset email:", err)
+		}
+	}
+	c.JSON(http.StatusOK, "if the email address is registered, a password reset email has been sent")
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword.  Every
+// session of the user ends, wherever it was.
+func (app *App) ResetPassword(c *gin.Context) {
+	var reset passwordReset
+	if err := c.ShouldBindJSON(&reset); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	if reset.Password == "" {
+		c.JSON(http.StatusUnprocessableEntity, "password is required")
+		return
+	}
+	if !app.allowReset(c, "reset-ip:"+c.ClientIP(), app.config.AuthConfig.ResetIPLimit) {
+		return
+	}
+
+	ad, err := app.auth.VerifyActionToken(authentication.ActionResetPassword, reset.Token)
+	if err == authentication.ErrInvalidActionToken {
+		c.JSON(http.StatusForbidden, "invalid or expired password reset token")
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	hash, err := app.hasher.Hash(reset.Password)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if err = app.auth.ConsumeActionToken(ad); err == authentication.ErrInvalidActionToken {
+		c.JSON(http.StatusForbidden, "invalid or expired password reset token")
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if err = app.db.UpdatePassword(ad.UserId, hash); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if err = app.auth.RevokeUserTokens(ad.UserId); err != nil {
+		glog.Error("Error revoking sessions after a password reset:", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if err = app.auth.RevokeActionTokens(authentication.ActionResetPassword, ad.UserId); err != nil {
+		glog.Warning("Error revoking password reset tokens:", err)
+	}
+	// The token was emailed, so it proves the address too.
+	if _, err = app.db.VerifyUser(ad.UserId, time.Now()); err != nil {
+		glog.Warning("Error verifying user after a password reset:", err)
+	}
+	c.JSON(http.StatusOK, "password reset, please log in again")
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"time"
+)
+
+// runReminders emails the owners of tasks whose reminders are due, checking
+// every interval.  It never returns.
+func (app *App) runReminders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		app.sendDueReminders(now)
+	}
+}
+
+func (app *App) sendDueReminders(now time.Time) {
+	reminders, err := app.db.DueReminders(now)
+	if err != nil {
+		glog.Error("Error reading due reminders:", err)
+		return
+	}
+	for _, r := range reminders {
+		// Claim before sending, so that a reminder is sent once even with several instances running.
+		claimed, err := app.db.ClaimReminder(r.TaskID, r.RemindAt)
+		if err != nil {
+			glog.Error("Error claiming reminder:", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		dueAt := r.DueAt
+		// An empty TimeZone loads UTC.
+		if loc, err := time.LoadLocation(r.TimeZone); err == nil {
+			dueAt = dueAt.In(loc)
+		}
+		subject := fmt.Sprintf("Reminder: %s", r.Title)
+		body := fmt.Sprintf("Your task \"%s\" is due %s.", r.Title, dueAt.Format("Mon, 02 Jan 2006 15:04 MST"))
+		if err := app.sendEmail(r.Email, subject, body); err != nil {
+			glog.Error("Error sending reminder email:", err)
+		}
+	}
+}
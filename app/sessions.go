@@ -0,0 +1,58 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetSessions lists the sessions of the user, one per login that has not
+// ended.  The session of the request is marked current.
+func (app *App) GetSessions(c *gin.Context) {
+	access, err := requestAccess(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	sessions, err := app.auth.Sessions(access.UserId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == access.FamilyUuid
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession ends one session of the user, which may be the current one.
+func (app *App) RevokeSession(c *gin.Context) {
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	found, err := app.auth.RevokeSession(userId, c.Param("session-id"))
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, "session not found")
+		return
+	}
+	c.JSON(http.StatusOK, "session revoked")
+}
+
+// LogoutAll ends every session of the user, including the current one.
+func (app *App) LogoutAll(c *gin.Context) {
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err = app.auth.RevokeUserTokens(userId); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "Successfully logged out of all sessions")
+}
@@ -0,0 +1,53 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, cut to 6 digits.
+func TestCode(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	for _, test := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		code, err := Code(secret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if code != test.code {
+			t.Errorf("Code at %d: got %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now))
+
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("Validate now: got %d, %v, want %d", step, ok, Step(now))
+	}
+	if _, ok := Validate(strings.ToLower(secret), " "+code+" ", now.Add(period*time.Second)); !ok {
+		t.Errorf("Validate one period later failed")
+	}
+	if _, ok := Validate(secret, code, now.Add(2*period*time.Second)); ok {
+		t.Errorf("Validate two periods later succeeded")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Errorf("Validate of a short code succeeded")
+	}
+}
@@ -0,0 +1,283 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"github.com/tintash-training/todo-api/app/totp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount = 10
+	// Attempts allowed per user in every ChallengeTTL until a code is right,
+	// which keeps the million TOTP codes from being guessed.
+	secondFactorAttempts = 5
+)
+
+// secondFactor is either a code of the authenticator app or a recovery code.
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery-code"`
+}
+
+type twoFactorLogin struct {
+	ChallengeToken string `json:"challenge-token"`
+	Device         string `json:"device"`
+	// Scope replaces the scope given with the password, if any.
+	Scope string `json:"scope"`
+	secondFactor
+}
+
+// newRecoveryCodes returns recovery codes to show the user and their hashes
+// to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, hashes := []string{}, []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		random := make([]byte, 10)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(random))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and separators.  Recovery codes are random
+// enough for a fast hash.
+func hashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkSecondFactor uses up the second factor of the user if it is valid.  It
+// answers the request itself when it is not.  Every attempt is counted before
+// the code is checked, so that concurrent guesses cannot slip past the limit,
+// and a valid code resets the count.
+func (app *App) checkSecondFactor(c *gin.Context, user *models.User, factor secondFactor) bool {
+	limitKey := "2fa:" + strconv.FormatUint(user.ID, 10)
+	allowed, err := app.auth.Allow(limitKey, secondFactorAttempts, app.config.AuthConfig.ChallengeTTL)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, "too many attempts, please try again later")
+		return false
+	}
+
+	valid := false
+	switch {
+	case factor.Code != "":
+		if step, ok := totp.Validate(user.TOTPSecret, factor.Code, time.Now()); ok {
+			// A code cannot be used twice, even within its period.
+			valid, err = app.db.UseTOTPStep(user.ID, step)
+		}
+	case factor.RecoveryCode != "":
+		valid, err = app.db.UseRecoveryCode(user.ID, hashRecoveryCode(factor.RecoveryCode))
+	default:
+		c.JSON(http.StatusUnprocessableEntity, "code or recovery-code is required")
+		return false
+	}
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, "invalid code")
+		return false
+	}
+	if err = app.auth.ResetLimit(limitKey); err != nil {
+		glog.Warning("Error resetting the second factor attempts:", err)
+	}
+	return true
+}
+
+// currentUser returns the authenticated user.  It answers the request itself
+// when there is none.
+func (app *App) currentUser(c *gin.Context) (*models.User, bool) {
+	userId, err := requestUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	user, err := app.db.ReadUserByID(userId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	return user, true
+}
+
+// challengeSecondFactor answers the first step of the login of a user with
+// two-factor authentication.  The challenge token stands for the password in
+// the second step, and carries the granted scopes to it.
+func (app *App) challengeSecondFactor(c *gin.Context, user *models.User, scopes []string) {
+	token, err := app.auth.CreateLoginChallenge(user.ID, scopes, app.config.AuthConfig.ChallengeTTL)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"challenge-token": token})
+}
+
+// LoginTwoFactor is the second step of the login of a user with two-factor
+// authentication.
+func (app *App) LoginTwoFactor(c *gin.Context) {
+	var login twoFactorLogin
+	if err := c.ShouldBindJSON(&login); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	scopes, err := authentication.ParseScope(login.Scope)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ad, err := app.auth.VerifyActionToken(authentication.ActionLogin2FA, login.ChallengeToken)
+	if err == authentication.ErrInvalidActionToken {
+		c.JSON(http.StatusUnauthorized, "invalid or expired challenge-token")
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	user, err := app.db.ReadUserByID(ad.UserId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.SuspendedAt != nil || user.TOTPEnabledAt == nil {
+		// The account changed since the first step.  Start over.
+		c.JSON(http.StatusUnauthorized, "invalid or expired challenge-token")
+		return
+	}
+	if scopes == nil {
+		scopes = ad.Scopes
+	}
+	// The role may have changed since the first step.
+	if scopes, err = authentication.GrantedScopes(scopes, user.Role); err != nil {
+		c.JSON(http.StatusForbidden, err.Error())
+		return
+	}
+	if !app.checkSecondFactor(c, user, login.secondFactor) {
+		return
+	}
+	if err = app.auth.ConsumeActionToken(ad); err == authentication.ErrInvalidActionToken {
+		c.JSON(http.StatusUnauthorized, "invalid or expired challenge-token")
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	app.issueTokens(c, user, login.Device, scopes)
+}
+
+// EnrollTwoFactor starts two-factor authentication with a new secret, which
+// the user adds to an authenticator app with the otpauth URI.  It is required
+// once ConfirmTwoFactor has checked a code of the app.
+func (app *App) EnrollTwoFactor(c *gin.Context) {
+	user, ok := app.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, "two-factor authentication is enabled already")
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if _, err = app.db.SetTOTPSecret(user.ID, secret); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth-uri": totp.URI(app.config.AuthConfig.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor authentication and answers with the
+// recovery codes, which are not shown again.
+func (app *App) ConfirmTwoFactor(c *gin.Context) {
+	var factor secondFactor
+	if err := c.ShouldBindJSON(&factor); err != nil || factor.Code == "" {
+		c.JSON(http.StatusUnprocessableEntity, "code is required")
+		return
+	}
+	user, ok := app.currentUser(c)
+	if !ok {
+		return
+	}
+	switch {
+	case user.TOTPEnabledAt != nil:
+		c.JSON(http.StatusConflict, "two-factor authentication is enabled already")
+		return
+	case user.TOTPSecret == "":
+		c.JSON(http.StatusConflict, "enroll-2fa first")
+		return
+	}
+	if !app.checkSecondFactor(c, user, secondFactor{Code: factor.Code}) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	rows, err := app.db.EnableTOTP(user.ID, time.Now(), hashes)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		c.JSON(http.StatusConflict, "two-factor authentication is enabled already")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery-codes": codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off.  It takes a code or a
+// recovery code, so that a stolen access token is not enough.
+func (app *App) DisableTwoFactor(c *gin.Context) {
+	var factor secondFactor
+	if err := c.ShouldBindJSON(&factor); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	user, ok := app.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+	if !app.checkSecondFactor(c, user, factor) {
+		return
+	}
+	if _, err := app.db.DisableTOTP(user.ID); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "two-factor authentication disabled")
+}
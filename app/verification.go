@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tintash-training/todo-api/app/authentication"
+	"github.com/tintash-training/todo-api/app/models"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// sendVerificationEmail emails the user a link that verifies their address.
+// Links sent before stop working.
+func (app *App) sendVerificationEmail(user *models.User) error {
+	err := app.auth.RevokeActionTokens(authentication.ActionVerifyEmail, user.ID)
+	if err != nil {
+		return err
+	}
+	ttl := app.config.AuthConfig.VerificationTTL
+	token, err := app.auth.CreateActionToken(authentication.ActionVerifyEmail, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	link := app.config.AuthConfig.PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Open this link within %s to verify your email address:\n\n%s\n", ttl, link)
+	return app.sendEmail(user.Email, "Verify your email address", body)
+}
+
+// VerifyEmail is the target of the link of the verification email.
+func (app *App) VerifyEmail(c *gin.Context) {
+	ad, err := app.auth.VerifyActionToken(authentication.ActionVerifyEmail, c.Query("token"))
+	if err == nil {
+		err = app.auth.ConsumeActionToken(ad)
+	}
+	if err == authentication.ErrInvalidActionToken {
+		c.JSON(http.StatusForbidden, "invalid or expired verification link")
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = app.db.VerifyUser(ad.UserId, time.Now()); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "email verified")
+}
+
+// ResendVerification sends another verification email.  It takes the login
+// details, since unverified users may not be able to log in.
+func (app *App) ResendVerification(c *gin.Context) {
+	var u models.User
+	if err := c.ShouldBindJSON(&u); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	user, ok := app.authenticate(c, u.Email, u.Password)
+	if !ok {
+		return
+	}
+	if user.VerifiedAt != nil {
+		c.JSON(http.StatusConflict, "email already verified")
+		return
+	}
+
+	if err := app.sendVerificationEmail(user); err != nil {
+		glog.Error("Error sending verification email:", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, "verification email sent")
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"github.com/tintash-training/todo-api/app"
 	"github.com/tintash-training/todo-api/app/config"
+	"os"
 )
 
 func main() {
@@ -11,6 +12,12 @@ func main() {
 	flag.Set("stderrthreshold", "INFO")
 	flag.Parse()
 	config := config.GetConf()
+	if flag.Arg(0) == "migrate" {
+		os.Exit(migrate(config.DBConfig, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "grant-role" {
+		os.Exit(grantRole(config.DBConfig, flag.Args()[1:]))
+	}
 	app := &app.App{}
 	app.Start(config)
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `usage: todo-api migrate up [-dry-run] [version]
+       todo-api migrate down [-dry-run] [steps]
+       todo-api migrate status`
+
+// migrate runs the migrate subcommand and returns the process exit code.
+func migrate(dbConfig *config.DBConfig, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the migrations and their SQL without running them")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		flags.PrintDefaults()
+	}
+	// Flags may come before, between or after the arguments, which the flag
+	// package alone would stop at.
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			flags.Usage()
+			return 2
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		flags.Usage()
+		return 2
+	}
+
+	switch positional[0] {
+	case "up", "down":
+	case "status":
+		if len(positional) == 2 {
+			flags.Usage()
+			return 2
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	var number int64
+	if len(positional) == 2 {
+		var err error
+		number, err = strconv.ParseInt(positional[1], 10, 64)
+		if err != nil || number < 1 {
+			flags.Usage()
+			return 2
+		}
+	}
+
+	migrator, err := models.OpenMigrator(dbConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer migrator.Close()
+
+	switch positional[0] {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-40s %s\n", status.Version, status.Name, applied)
+		}
+	case "up":
+		done, err := migrator.Up(number, *dryRun)
+		printMigrations("up", done, *dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "down":
+		steps := 1
+		if number != 0 {
+			steps = int(number)
+		}
+		done, err := migrator.Down(steps, *dryRun)
+		printMigrations("down", done, *dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func printMigrations(direction string, done []models.Migration, dryRun bool) {
+	if len(done) == 0 {
+		fmt.Println("nothing to migrate")
+		return
+	}
+	for _, migration := range done {
+		if !dryRun {
+			fmt.Printf("%s %d  %s\n", direction, migration.Version, migration.Name)
+			continue
+		}
+		query := migration.Down
+		if direction == "up" {
+			query = migration.Up
+		}
+		fmt.Printf("-- would migrate %s %d  %s%s\n\n", direction, migration.Version, migration.Name, query)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tintash-training/todo-api/app/config"
+	"github.com/tintash-training/todo-api/app/models"
+	"os"
+)
+
+const grantRoleUsage = `usage: todo-api grant-role email user|support|admin`
+
+// grantRole runs the grant-role subcommand, which appoints the first admin,
+// and returns the process exit code.  The new role applies from the next login
+// or token refresh, so within the lifetime of an access token.
+func grantRole(dbConfig *config.DBConfig, args []string) int {
+	if len(args) != 2 || !models.ValidUserRole(args[1]) {
+		fmt.Fprintln(os.Stderr, grantRoleUsage)
+		return 2
+	}
+	ds, err := models.ConnectDS(dbConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer ds.Close()
+
+	user, err := ds.ReadUser(args[0])
+	if err == nil && user == nil {
+		err = fmt.Errorf("no user %s", args[0])
+	}
+	if err == nil {
+		_, err = ds.SetUserRole(user.ID, args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s is now %s\n", user.Email, args[1])
+	return 0
+}